@@ -4,6 +4,9 @@ import (
 	"fmt"
 	"log/slog"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/google/uuid"
 	"go.opentelemetry.io/otel/attribute"
@@ -19,6 +22,7 @@ const (
 	stdout    Exporter = "stdout"
 	stderr    Exporter = "stderr"
 	otlp      Exporter = "otlp"
+	fileExp   Exporter = "file"
 )
 
 type Client string
@@ -26,6 +30,23 @@ type Client string
 const (
 	grpcClient Client = "grpc"
 	httpClient Client = "http"
+	// OTLP/HTTP with the JSON encoding, traces only
+	jsonClient Client = "http/json"
+)
+
+type SamplerType string
+
+const (
+	alwaysOn                SamplerType = "always_on"
+	alwaysOff               SamplerType = "always_off"
+	traceIDRatio            SamplerType = "traceidratio"
+	parentBasedAlwaysOn     SamplerType = "parentbased_always_on"
+	parentBasedAlwaysOff    SamplerType = "parentbased_always_off"
+	parentBasedTraceIDRatio SamplerType = "parentbased_traceidratio"
+	adaptive                SamplerType = "adaptive"
+	parentBasedAdaptive     SamplerType = "parentbased_adaptive"
+	remote                  SamplerType = "remote"
+	parentBasedRemote       SamplerType = "parentbased_remote"
 )
 
 // Resource describes an entity about which identifying information and metadata is exposed.
@@ -37,6 +58,85 @@ type Resource struct {
 	ServiceVersionKey    string `mapstructure:"service_version"`
 }
 
+// Sampler configures the head sampler used by the tracer provider.
+type Sampler struct {
+	// Type of the sampler, defaults to parentbased_always_on
+	Type SamplerType `mapstructure:"type"`
+	// Ratio used by the traceidratio samplers, must be in the [0, 1] range. Defaults to 1.0
+	Ratio *float64 `mapstructure:"ratio"`
+	// TargetRate is the number of traces per second the adaptive samplers aim for. Defaults to 100
+	TargetRate float64 `mapstructure:"target_rate"`
+	// Remote configures the remote sampling strategies endpoint used by the remote samplers
+	Remote *RemoteSampler `mapstructure:"remote"`
+	// Rules are evaluated in order for the root HTTP server spans before the sampler above, the first matching rule wins.
+	// The requests continuing an upstream trace are sampled by the sampler above
+	Rules []*SamplingRule `mapstructure:"rules"`
+}
+
+// SamplingRule sets the sampling ratio for the HTTP requests matching all the non-empty criteria.
+type SamplingRule struct {
+	// Method is the HTTP method, case-insensitive
+	Method string `mapstructure:"method"`
+	// PathPrefix matches the beginning of the URL path
+	PathPrefix string `mapstructure:"path_prefix"`
+	// PathRegex matches the URL path
+	PathRegex string `mapstructure:"path_regex"`
+	// Host matches the server address (without the port), case-insensitive
+	Host string `mapstructure:"host"`
+	// Ratio of the matching traces to sample, must be in the [0, 1] range
+	Ratio float64 `mapstructure:"ratio"`
+}
+
+// SignalExporter configures the exporter of a telemetry signal. The options left empty
+// are taken from the traces configuration, except the custom_url.
+type SignalExporter struct {
+	// Exporter type, can be stdout, stderr or otlp
+	Exporter Exporter `mapstructure:"exporter"`
+	// Client, http or grpc
+	Client Client `mapstructure:"client"`
+	// Endpoint to connect
+	Endpoint string `mapstructure:"endpoint"`
+	// CustomURL to use, has effect only for the HTTP exporter
+	CustomURL string `mapstructure:"custom_url"`
+	// Insecure endpoint (http)
+	Insecure *bool `mapstructure:"insecure"`
+	// Compress - use gzip compression
+	Compress *bool `mapstructure:"compress"`
+	// Headers for the otlp protocol
+	Headers map[string]string `mapstructure:"headers"`
+}
+
+// initDefault fills the empty values from the traces configuration, which must be already defaulted
+func (e *SignalExporter) initDefault(c *Config, protocolEnv string, log *slog.Logger) {
+	if e.Exporter == "" {
+		e.Exporter = c.Exporter
+	}
+
+	switch e.Client {
+	case grpcClient, httpClient, jsonClient:
+		// ok value, do nothing
+	case "":
+		e.Client = c.Client
+		setClientFromEnv(&e.Client, log, protocolEnv)
+	default:
+		log.Warn("unknown exporter client", "client", string(e.Client))
+		e.Client = c.Client
+	}
+
+	if e.Endpoint == "" {
+		e.Endpoint = c.Endpoint
+	}
+	if e.Insecure == nil {
+		e.Insecure = toPtr(c.Insecure)
+	}
+	if e.Compress == nil {
+		e.Compress = toPtr(c.Compress)
+	}
+	if e.Headers == nil {
+		e.Headers = c.Headers
+	}
+}
+
 type Config struct {
 	// Resource describes an entity about which identifying information and metadata is exposed.
 	Resource *Resource `mapstructure:"resource"`
@@ -44,11 +144,33 @@ type Config struct {
 	Insecure bool `mapstructure:"insecure"`
 	// Compress - use gzip compression
 	Compress bool `mapstructure:"compress"`
-	// Exporter type, can be zipkin,stdout or otlp
+	// Sampler configures which spans are recorded and exported
+	Sampler *Sampler `mapstructure:"sampler"`
+	// TailSampling enables the tail-based sampling of the head-sampled traces
+	TailSampling *TailSampling `mapstructure:"tail_sampling"`
+	// Metrics enables the metrics pipeline
+	Metrics *Metrics `mapstructure:"metrics"`
+	// Logs enables the logs pipeline
+	Logs *Logs `mapstructure:"logs"`
+	// Propagators used to extract and inject the trace context:
+	// tracecontext, baggage, b3, b3multi, jaeger, xray, ottrace or none. Defaults to tracecontext, baggage, jaeger
+	Propagators []string `mapstructure:"propagators"`
+	// HTTP middleware configuration
+	HTTP *HTTP `mapstructure:"http"`
+	// Exporter type, can be zipkin,stdout, file or otlp
 	Exporter Exporter `mapstructure:"exporter"`
+	// File configures the file exporter
+	File *File `mapstructure:"file"`
+	// Queue enables the persistent on-disk queue in front of the exporter
+	Queue *Queue `mapstructure:"queue"`
+	// Exporters to send the spans to simultaneously, each one on its own batch processor.
+	// If empty, the top-level exporter options are used
+	Exporters []*SpanExporter `mapstructure:"exporters"`
+	// Routing dispatches the spans to the route exporters by an attribute value, e.g. per tenant
+	Routing *Routing `mapstructure:"routing"`
 	// CustomURL to use to send spans, has effect only for the HTTP exporter
 	CustomURL string `mapstructure:"custom_url"`
-	// Client
+	// Client used by the otlp exporter: http (protobuf), grpc or http/json (traces only)
 	Client Client `mapstructure:"client"`
 	// Endpoint to connect
 	Endpoint string `mapstructure:"endpoint"`
@@ -79,16 +201,133 @@ func (c *Config) InitDefault(log *slog.Logger) {
 	}
 
 	switch c.Client {
-	case grpcClient, httpClient:
+	case grpcClient, httpClient, jsonClient:
 		// ok value, do nothing
 	case "":
 		c.Client = httpClient
-		setClientFromEnv(&c.Client, log)
+		setClientFromEnv(&c.Client, log, "OTEL_EXPORTER_OTLP_TRACES_PROTOCOL", "OTEL_EXPORTER_OTLP_PROTOCOL")
 	default:
 		log.Warn("unknown exporter client", "client", string(c.Client))
 		c.Client = httpClient
 	}
 
+	if c.Sampler == nil {
+		c.Sampler = &Sampler{}
+	}
+
+	switch c.Sampler.Type {
+	case alwaysOn, alwaysOff, traceIDRatio, parentBasedAlwaysOn, parentBasedAlwaysOff, parentBasedTraceIDRatio,
+		adaptive, parentBasedAdaptive, remote, parentBasedRemote:
+		// ok value, do nothing
+	case "":
+		c.Sampler.Type = parentBasedAlwaysOn
+		setSamplerFromEnv(c.Sampler, log)
+	default:
+		log.Warn("unknown sampler type", "type", string(c.Sampler.Type))
+		c.Sampler.Type = parentBasedAlwaysOn
+	}
+
+	switch {
+	case c.Sampler.Ratio == nil:
+		c.Sampler.Ratio = toPtr(1.0)
+	case *c.Sampler.Ratio < 0 || *c.Sampler.Ratio > 1:
+		log.Warn("sampler ratio is out of the [0, 1] range, using 1.0", "ratio", *c.Sampler.Ratio)
+		c.Sampler.Ratio = toPtr(1.0)
+	}
+
+	if c.Sampler.TargetRate <= 0 {
+		c.Sampler.TargetRate = 100
+	}
+
+	if c.Sampler.Type == remote || c.Sampler.Type == parentBasedRemote {
+		if c.Sampler.Remote == nil {
+			c.Sampler.Remote = &RemoteSampler{}
+		}
+		if c.Sampler.Remote.URL == "" {
+			c.Sampler.Remote.URL = "http://localhost:5778/sampling"
+		}
+		if c.Sampler.Remote.PollingInterval <= 0 {
+			c.Sampler.Remote.PollingInterval = time.Minute
+		}
+		if c.Sampler.Remote.MaxOperations <= 0 {
+			c.Sampler.Remote.MaxOperations = 256
+		}
+	}
+
+	for _, rule := range c.Sampler.Rules {
+		if rule.Ratio < 0 || rule.Ratio > 1 {
+			log.Warn("sampling rule ratio is out of the [0, 1] range, using 1.0", "ratio", rule.Ratio)
+			rule.Ratio = 1.0
+		}
+	}
+
+	// the top-level exporter is the only one if the list is not configured
+	if len(c.Exporters) == 0 {
+		c.Exporters = []*SpanExporter{{
+			SignalExporter: SignalExporter{
+				Exporter:  c.Exporter,
+				Client:    c.Client,
+				Endpoint:  c.Endpoint,
+				CustomURL: c.CustomURL,
+				Insecure:  toPtr(c.Insecure),
+				Compress:  toPtr(c.Compress),
+				Headers:   c.Headers,
+			},
+			File:  c.File,
+			Queue: c.Queue,
+		}}
+	}
+	for _, e := range c.Exporters {
+		e.initDefault(c, log)
+	}
+	if c.Routing != nil {
+		c.Routing.initDefault(c, log)
+	}
+
+	if c.TailSampling != nil {
+		if c.TailSampling.DecisionWait <= 0 {
+			c.TailSampling.DecisionWait = 10 * time.Second
+		}
+		if c.TailSampling.MaxTraces <= 0 {
+			c.TailSampling.MaxTraces = 10000
+		}
+		if c.TailSampling.MaxSpansPerTrace <= 0 {
+			c.TailSampling.MaxSpansPerTrace = 1000
+		}
+		if c.TailSampling.Ratio < 0 || c.TailSampling.Ratio > 1 {
+			log.Warn("tail sampling ratio is out of the [0, 1] range, using 0", "ratio", c.TailSampling.Ratio)
+			c.TailSampling.Ratio = 0
+		}
+		ts := c.TailSampling
+		if !ts.Errors && ts.MinDuration <= 0 && len(ts.Attributes) == 0 && ts.Ratio == 0 {
+			log.Warn("tail sampling has no policies and the ratio is 0, all the traces are dropped")
+		}
+	}
+
+	if c.Metrics != nil {
+		c.Metrics.initDefault(c, log)
+	}
+
+	if c.Logs != nil {
+		c.Logs.initDefault(c, log)
+	}
+
+	if len(c.Propagators) == 0 {
+		// https://opentelemetry.io/docs/languages/sdk-configuration/general/#otel_propagators
+		if env := os.Getenv("OTEL_PROPAGATORS"); env != "" {
+			for name := range strings.SplitSeq(env, ",") {
+				c.Propagators = append(c.Propagators, strings.TrimSpace(name))
+			}
+		} else {
+			c.Propagators = []string{"tracecontext", "baggage", "jaeger"}
+		}
+	}
+
+	if c.HTTP == nil {
+		c.HTTP = &HTTP{}
+	}
+	c.HTTP.initDefault(log)
+
 	if c.Resource == nil {
 		c.Resource = &Resource{}
 	}
@@ -100,13 +339,15 @@ func (c *Config) InitDefault(log *slog.Logger) {
 	fillValue(&c.Resource.ServiceNamespaceKey, "", envAttrs, semconv.ServiceNamespaceKey, fmt.Sprintf("%s-%s", c.Resource.ServiceNameKey, uuid.NewString()))
 }
 
-func setClientFromEnv(client *Client, log *slog.Logger) {
+// setClientFromEnv sets the client from the first non-empty protocol env variable
+func setClientFromEnv(client *Client, log *slog.Logger, envs ...string) {
 	// https://opentelemetry.io/docs/specs/otel/protocol/exporter/#specify-protocol
-	exporterEnv := "OTEL_EXPORTER_OTLP_TRACES_PROTOCOL"
-	exporterVal := os.Getenv(exporterEnv)
-	if exporterVal == "" {
-		exporterEnv = "OTEL_EXPORTER_OTLP_PROTOCOL"
+	var exporterEnv, exporterVal string
+	for _, exporterEnv = range envs {
 		exporterVal = os.Getenv(exporterEnv)
+		if exporterVal != "" {
+			break
+		}
 	}
 	switch exporterVal {
 	case "":
@@ -116,12 +357,99 @@ func setClientFromEnv(client *Client, log *slog.Logger) {
 	case "http/protobuf":
 		*client = httpClient
 	case "http/json":
-		log.Warn("unsupported exporter protocol", "env.name", exporterEnv, "env.value", exporterVal)
+		*client = jsonClient
 	default:
 		log.Warn("unknown exporter protocol", "env.name", exporterEnv, "env.value", exporterVal)
 	}
 }
 
+func setSamplerFromEnv(sampler *Sampler, log *slog.Logger) {
+	// https://opentelemetry.io/docs/languages/sdk-configuration/general/#otel_traces_sampler
+	samplerVal := os.Getenv("OTEL_TRACES_SAMPLER")
+	switch samplerVal {
+	case "":
+		// env var not set, do not change the sampler
+		return
+	case "always_on", "always_off", "traceidratio", "parentbased_always_on", "parentbased_always_off", "parentbased_traceidratio":
+		sampler.Type = SamplerType(samplerVal)
+	case "jaeger_remote":
+		sampler.Type = remote
+	case "parentbased_jaeger_remote":
+		sampler.Type = parentBasedRemote
+	default:
+		log.Warn("unknown sampler", "env.name", "OTEL_TRACES_SAMPLER", "env.value", samplerVal)
+		return
+	}
+
+	argVal := os.Getenv("OTEL_TRACES_SAMPLER_ARG")
+	if argVal == "" {
+		return
+	}
+
+	if sampler.Type == remote || sampler.Type == parentBasedRemote {
+		setRemoteSamplerArgs(sampler, argVal, log)
+		return
+	}
+
+	if sampler.Ratio != nil {
+		return
+	}
+	ratio, err := strconv.ParseFloat(argVal, 64)
+	if err != nil {
+		log.Warn("invalid sampler argument", "env.name", "OTEL_TRACES_SAMPLER_ARG", "env.value", argVal, "error", err)
+		return
+	}
+	sampler.Ratio = &ratio
+}
+
+// setRemoteSamplerArgs parses the jaeger_remote sampler arguments:
+// endpoint=http://localhost:14250,pollingIntervalMs=5000,initialSamplingRate=0.25
+func setRemoteSamplerArgs(sampler *Sampler, argVal string, log *slog.Logger) {
+	if sampler.Remote == nil {
+		sampler.Remote = &RemoteSampler{}
+	}
+
+	for arg := range strings.SplitSeq(argVal, ",") {
+		key, value, ok := strings.Cut(arg, "=")
+		if !ok {
+			log.Warn("invalid sampler argument", "env.name", "OTEL_TRACES_SAMPLER_ARG", "argument", arg)
+			continue
+		}
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+
+		switch key {
+		case "endpoint":
+			if sampler.Remote.URL == "" {
+				sampler.Remote.URL = value
+			}
+		case "pollingIntervalMs":
+			ms, err := strconv.Atoi(value)
+			if err != nil {
+				log.Warn("invalid sampler argument", "env.name", "OTEL_TRACES_SAMPLER_ARG", "argument", arg, "error", err)
+				continue
+			}
+			if sampler.Remote.PollingInterval == 0 {
+				sampler.Remote.PollingInterval = time.Duration(ms) * time.Millisecond
+			}
+		case "initialSamplingRate":
+			ratio, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				log.Warn("invalid sampler argument", "env.name", "OTEL_TRACES_SAMPLER_ARG", "argument", arg, "error", err)
+				continue
+			}
+			if sampler.Ratio == nil {
+				sampler.Ratio = &ratio
+			}
+		default:
+			log.Warn("unknown sampler argument", "env.name", "OTEL_TRACES_SAMPLER_ARG", "argument", arg)
+		}
+	}
+}
+
+func toPtr[T any](v T) *T {
+	return &v
+}
+
 func fillValue(target *string, fromConf string, fromResource *resource.Resource, fromResourceKey attribute.Key, fromDefault string) {
 	if *target != "" {
 		return
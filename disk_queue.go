@@ -0,0 +1,401 @@
+package otel
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/roadrunner-server/errors"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+const queueFileExt = ".pb"
+
+// Queue configures the persistent on-disk queue in front of the exporter. The batches are written to the directory
+// before being sent, and are replayed when the backend is available again, including after a restart. Only the batches
+// rejected by the backend are dropped, the failed deliveries are retried until MaxSize is exceeded.
+type Queue struct {
+	// Dir of the queue, required and must be unique per exporter. It's created with the owner-only permissions
+	Dir string `mapstructure:"dir"`
+	// MaxSize of the queue in megabytes, the oldest batches are dropped when it's exceeded. Defaults to 256
+	MaxSize int `mapstructure:"max_size"`
+	// RetryInterval between the send attempts while the backend is unavailable. Defaults to 5s
+	RetryInterval time.Duration `mapstructure:"retry_interval"`
+}
+
+func (q *Queue) initDefault() {
+	if q.MaxSize <= 0 {
+		q.MaxSize = 256
+	}
+	if q.RetryInterval <= 0 {
+		q.RetryInterval = 5 * time.Second
+	}
+}
+
+// checkQueueDirs makes sure every queue has its own directory, each queue replays
+// every batch found in its directory to its own backend
+func checkQueueDirs(c *Config) error {
+	queues := make([]*Queue, 0, len(c.Exporters))
+	for _, e := range c.Exporters {
+		queues = append(queues, e.Queue)
+	}
+	if c.Routing != nil {
+		for _, route := range c.Routing.Routes {
+			queues = append(queues, route.Queue)
+		}
+	}
+
+	dirs := make(map[string]struct{}, len(queues))
+	for _, q := range queues {
+		if q == nil {
+			continue
+		}
+		if q.Dir == "" {
+			return errors.Str("queue dir is required")
+		}
+		dir, err := filepath.Abs(q.Dir)
+		if err != nil {
+			return err
+		}
+		if _, ok := dirs[dir]; ok {
+			return errors.Errorf("queue dir %s is used by more than one exporter", q.Dir)
+		}
+		dirs[dir] = struct{}{}
+	}
+	return nil
+}
+
+type queuedBatch struct {
+	seq  uint64
+	size int64
+}
+
+// diskQueue is the otlptrace.Client persisting the batches and sending them with the next client in the background
+type diskQueue struct {
+	cfg  *Queue
+	next otlptrace.Client
+	// status of the last HTTP response of the next client, nil for the non-HTTP clients
+	status *statusRecorder
+	log    *slog.Logger
+
+	mu      sync.Mutex
+	batches []queuedBatch
+	size    int64
+	seq     uint64
+	dropped uint64
+	// the first batch failed and waits for the retry interval
+	retrying bool
+
+	notify chan struct{}
+	stopCh chan struct{}
+	wg     sync.WaitGroup
+}
+
+func newDiskQueue(cfg *Queue, next otlptrace.Client, status *statusRecorder, log *slog.Logger) *diskQueue {
+	return &diskQueue{
+		cfg:    cfg,
+		next:   next,
+		status: status,
+		log:    log,
+		notify: make(chan struct{}, 1),
+		stopCh: make(chan struct{}),
+	}
+}
+
+// Start loads the batches left by the previous run and starts the sending loop
+func (q *diskQueue) Start(ctx context.Context) error {
+	const op = errors.Op("otel_disk_queue_start")
+
+	if err := os.MkdirAll(q.cfg.Dir, 0o700); err != nil {
+		return errors.E(op, err)
+	}
+
+	entries, err := os.ReadDir(q.cfg.Dir)
+	if err != nil {
+		return errors.E(op, err)
+	}
+	for _, entry := range entries {
+		// partially written by the previous run
+		if strings.HasSuffix(entry.Name(), queueFileExt+".tmp") {
+			_ = os.Remove(filepath.Join(q.cfg.Dir, entry.Name()))
+			continue
+		}
+		seq, ok := parseQueueName(entry.Name())
+		if !ok {
+			continue
+		}
+		info, errI := entry.Info()
+		if errI != nil {
+			return errors.E(op, errI)
+		}
+		q.batches = append(q.batches, queuedBatch{seq: seq, size: info.Size()})
+		q.size += info.Size()
+		q.seq = max(q.seq, seq)
+	}
+	slices.SortFunc(q.batches, func(a, b queuedBatch) int {
+		return cmp.Compare(a.seq, b.seq)
+	})
+	if len(q.batches) > 0 {
+		q.log.Info("replaying the persisted span batches", "dir", q.cfg.Dir, "batches", len(q.batches), "bytes", q.size)
+	}
+
+	if err = q.next.Start(ctx); err != nil {
+		return errors.E(op, err)
+	}
+
+	q.wg.Add(1)
+	go q.loop()
+	q.wake()
+
+	return nil
+}
+
+// Stop makes the last attempt to send the queued batches within the context deadline, the rest is kept for the next run
+func (q *diskQueue) Stop(ctx context.Context) error {
+	close(q.stopCh)
+	q.wg.Wait()
+
+	if err := q.drain(ctx); err != nil {
+		batches, size := q.Backlog()
+		q.log.Warn("span batches are kept in the queue", "dir", q.cfg.Dir, "batches", batches, "bytes", size, "error", err)
+	}
+
+	return q.next.Stop(ctx)
+}
+
+// UploadTraces persists the batch, it's sent in the background
+func (q *diskQueue) UploadTraces(_ context.Context, protoSpans []*tracepb.ResourceSpans) error {
+	const op = errors.Op("otel_disk_queue_upload")
+
+	data, err := proto.Marshal(&coltracepb.ExportTraceServiceRequest{ResourceSpans: protoSpans})
+	if err != nil {
+		return errors.E(op, err)
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.seq++
+	batch := queuedBatch{seq: q.seq, size: int64(len(data))}
+
+	// write and rename, the partial files are never replayed
+	name := q.path(batch.seq)
+	if err = os.WriteFile(name+".tmp", data, 0o600); err != nil {
+		return errors.E(op, err)
+	}
+	if err = os.Rename(name+".tmp", name); err != nil {
+		return errors.E(op, err)
+	}
+
+	q.batches = append(q.batches, batch)
+	q.size += batch.size
+	q.evict()
+	// the failed batch is retried every RetryInterval, not on every new batch
+	if !q.retrying {
+		q.wake()
+	}
+
+	return nil
+}
+
+// Backlog returns the number and the size of the batches waiting in the queue
+func (q *diskQueue) Backlog() (int, int64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.batches), q.size
+}
+
+// Dropped returns the number of the batches dropped because the queue size limit was reached
+func (q *diskQueue) Dropped() uint64 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.dropped
+}
+
+// evict removes the oldest batches above the size limit, must be called under the lock
+func (q *diskQueue) evict() {
+	limit := int64(q.cfg.MaxSize) * 1024 * 1024
+	for q.size > limit && len(q.batches) > 1 {
+		oldest := q.batches[0]
+		if err := os.Remove(q.path(oldest.seq)); err != nil && !os.IsNotExist(err) {
+			q.log.Error("failed to remove the queued batch", "error", err)
+		}
+		q.batches = q.batches[1:]
+		q.size -= oldest.size
+		q.dropped++
+	}
+}
+
+func (q *diskQueue) wake() {
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (q *diskQueue) loop() {
+	defer q.wg.Done()
+
+	ticker := time.NewTicker(q.cfg.RetryInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-q.stopCh:
+			return
+		case <-q.notify:
+		case <-ticker.C:
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		go func() {
+			select {
+			case <-q.stopCh:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+		err := q.drain(ctx)
+		cancel()
+		if err != nil {
+			q.log.Debug("failed to send the queued span batches, retrying", "error", err)
+		}
+	}
+}
+
+// drain sends the batches in order until the queue is empty or the first failed delivery,
+// the batches rejected by the backend are dropped
+func (q *diskQueue) drain(ctx context.Context) error {
+	for {
+		q.mu.Lock()
+		if len(q.batches) == 0 {
+			q.retrying = false
+			q.mu.Unlock()
+			return nil
+		}
+		batch := q.batches[0]
+		q.mu.Unlock()
+
+		data, err := os.ReadFile(q.path(batch.seq))
+		if err == nil {
+			req := &coltracepb.ExportTraceServiceRequest{}
+			if err = proto.Unmarshal(data, req); err != nil {
+				q.log.Error("dropping the corrupted queued batch", "seq", batch.seq, "error", err)
+			} else if err = q.upload(ctx, req.GetResourceSpans()); err != nil {
+				if !q.rejected(err) {
+					q.mu.Lock()
+					q.retrying = true
+					q.mu.Unlock()
+					return err
+				}
+
+				q.log.Warn("dropping the queued span batch rejected by the backend", "seq", batch.seq, "error", err)
+				q.mu.Lock()
+				if q.remove(batch) {
+					q.dropped++
+				}
+				q.mu.Unlock()
+				continue
+			}
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+
+		q.mu.Lock()
+		q.remove(batch)
+		q.mu.Unlock()
+	}
+}
+
+func (q *diskQueue) upload(ctx context.Context, protoSpans []*tracepb.ResourceSpans) error {
+	if q.status != nil {
+		q.status.reset()
+	}
+	return q.next.UploadTraces(ctx, protoSpans)
+}
+
+// remove deletes the sent or dropped first batch, must be called under the lock.
+// Reports false if the batch was already evicted while being sent.
+func (q *diskQueue) remove(batch queuedBatch) bool {
+	if len(q.batches) == 0 || q.batches[0].seq != batch.seq {
+		return false
+	}
+	_ = os.Remove(q.path(batch.seq))
+	q.batches = q.batches[1:]
+	q.size -= batch.size
+	return true
+}
+
+// rejected reports whether the backend refused the batch itself, so sending it again can't succeed:
+// the gRPC InvalidArgument status or an HTTP 4xx status other than 408 and 429. The unavailable
+// backend and the network errors are retried, the backlog is limited by MaxSize.
+func (q *diskQueue) rejected(err error) bool {
+	if st, ok := status.FromError(err); ok {
+		return st.Code() == codes.InvalidArgument
+	}
+	if q.status == nil {
+		return false
+	}
+
+	code := q.status.last()
+	switch {
+	case code == http.StatusRequestTimeout, code == http.StatusTooManyRequests:
+		return false
+	default:
+		return code >= 400 && code < 500
+	}
+}
+
+// statusRecorder is the transport of the HTTP clients remembering the status of the last response,
+// the clients return the plain errors on the failed deliveries
+type statusRecorder struct {
+	next   http.RoundTripper
+	status atomic.Int32
+}
+
+func newStatusRecorder() *statusRecorder {
+	return &statusRecorder{next: http.DefaultTransport.(*http.Transport).Clone()}
+}
+
+func (sr *statusRecorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := sr.next.RoundTrip(req)
+	if err == nil {
+		sr.status.Store(int32(resp.StatusCode)) //nolint:gosec
+	}
+	return resp, err
+}
+
+func (sr *statusRecorder) reset() {
+	sr.status.Store(0)
+}
+
+func (sr *statusRecorder) last() int {
+	return int(sr.status.Load())
+}
+
+func (q *diskQueue) path(seq uint64) string {
+	return filepath.Join(q.cfg.Dir, fmt.Sprintf("%020d%s", seq, queueFileExt))
+}
+
+func parseQueueName(name string) (uint64, bool) {
+	if !strings.HasSuffix(name, queueFileExt) {
+		return 0, false
+	}
+	seq, err := strconv.ParseUint(strings.TrimSuffix(name, queueFileExt), 10, 64)
+	return seq, err == nil
+}
@@ -0,0 +1,232 @@
+package otel
+
+import (
+	"context"
+	stdErrors "errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/roadrunner-server/errors"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// SpanExporter configures one of the span exporters. The options left empty are taken from the top-level configuration,
+// except the custom_url and file.
+type SpanExporter struct {
+	SignalExporter `mapstructure:",squash"`
+	// File configures the file exporter
+	File *File `mapstructure:"file"`
+	// Batch configures the batch processor of the exporter
+	Batch *Batch `mapstructure:"batch"`
+	// Queue enables the persistent on-disk queue in front of the otlp and file exporters
+	Queue *Queue `mapstructure:"queue"`
+}
+
+// Batch configures the batch span processor, the OTEL_BSP_* env variables or the SDK defaults are used for the empty values
+type Batch struct {
+	// Timeout is the maximum delay between the exports. Defaults to 5s
+	Timeout time.Duration `mapstructure:"timeout"`
+	// ExportTimeout is the maximum duration of an export. Defaults to 30s
+	ExportTimeout time.Duration `mapstructure:"export_timeout"`
+	// MaxQueueSize is the maximum number of the buffered spans, the spans are dropped when the queue is full. Defaults to 2048
+	MaxQueueSize int `mapstructure:"max_queue_size"`
+	// MaxExportBatchSize is the maximum number of the spans in an export. Defaults to 512
+	MaxExportBatchSize int `mapstructure:"max_export_batch_size"`
+}
+
+func (e *SpanExporter) initDefault(c *Config, log *slog.Logger) {
+	// the top-level client is already resolved from the env variables
+	if e.Client == "" {
+		e.Client = c.Client
+	}
+	e.SignalExporter.initDefault(c, "OTEL_EXPORTER_OTLP_TRACES_PROTOCOL", log)
+
+	if e.Exporter == fileExp {
+		if e.File == nil {
+			e.File = &File{}
+		}
+		e.File.initDefault(log)
+	}
+
+	if e.Queue != nil {
+		if e.Exporter != otlp && e.Exporter != fileExp {
+			log.Warn("the queue is supported only by the otlp and file exporters", "exporter", string(e.Exporter))
+			e.Queue = nil
+			return
+		}
+		e.Queue.initDefault()
+	}
+}
+
+// newSpanExporter returns the exporter and its queue, nil if the queue is not configured
+func newSpanExporter(cfg *SpanExporter, log *slog.Logger) (sdktrace.SpanExporter, *diskQueue, error) {
+	const op = errors.Op("otel_new_span_exporter")
+
+	var client otlptrace.Client
+	// the queue tells the batches rejected by the backend by the HTTP response status
+	var status *statusRecorder
+	if cfg.Queue != nil && cfg.Exporter == otlp && (cfg.Client == httpClient || cfg.Client == jsonClient) {
+		status = newStatusRecorder()
+	}
+
+	switch cfg.Exporter {
+	case stdout:
+		exporter, err := stdouttrace.New(stdouttrace.WithPrettyPrint(), stdouttrace.WithWriter(os.Stdout))
+		return exporter, nil, err
+	case stderr:
+		exporter, err := stdouttrace.New(stdouttrace.WithPrettyPrint(), stdouttrace.WithWriter(os.Stderr))
+		return exporter, nil, err
+	case jaegerExp:
+		return nil, nil, errors.Errorf("jaeger exporter is deprecated, use OTLP instead: https://github.com/roadrunner-server/roadrunner/issues/1699")
+	case zipkinExp:
+		return nil, nil, errors.Errorf("zipkin exporter is deprecated, use OTLP instead")
+	case fileExp:
+		client = newFileClient(cfg.File)
+	case otlp:
+		switch cfg.Client {
+		case httpClient:
+			options := httpOptions(&cfg.SignalExporter)
+			if status != nil {
+				options = append(options, otlptracehttp.WithHTTPClient(&http.Client{Transport: status}))
+			}
+			client = otlptracehttp.NewClient(options...)
+		case grpcClient:
+			client = otlptracegrpc.NewClient(grpcOptions(&cfg.SignalExporter)...)
+		case jsonClient:
+			jc := newOTLPJSONClient(&cfg.SignalExporter)
+			if status != nil {
+				jc.client.Transport = status
+			}
+			client = jc
+		default:
+			return nil, nil, errors.Errorf("unknown client: %s", cfg.Client)
+		}
+	default:
+		return nil, nil, errors.Errorf("unknown exporter: %s", cfg.Exporter)
+	}
+
+	var queue *diskQueue
+	if cfg.Queue != nil {
+		queue = newDiskQueue(cfg.Queue, client, status, log)
+		client = queue
+	}
+
+	// 1 min timeout
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	exporter, err := otlptrace.New(ctx, client)
+	if err != nil {
+		return nil, nil, errors.E(op, err)
+	}
+
+	return exporter, queue, nil
+}
+
+func batchOptions(cfg *Batch) []sdktrace.BatchSpanProcessorOption {
+	if cfg == nil {
+		return nil
+	}
+
+	var options []sdktrace.BatchSpanProcessorOption
+	if cfg.Timeout > 0 {
+		options = append(options, sdktrace.WithBatchTimeout(cfg.Timeout))
+	}
+	if cfg.ExportTimeout > 0 {
+		options = append(options, sdktrace.WithExportTimeout(cfg.ExportTimeout))
+	}
+	if cfg.MaxQueueSize > 0 {
+		options = append(options, sdktrace.WithMaxQueueSize(cfg.MaxQueueSize))
+	}
+	if cfg.MaxExportBatchSize > 0 {
+		options = append(options, sdktrace.WithMaxExportBatchSize(cfg.MaxExportBatchSize))
+	}
+
+	return options
+}
+
+func grpcOptions(cfg *SignalExporter) []otlptracegrpc.Option {
+	var options []otlptracegrpc.Option
+	if *cfg.Insecure {
+		options = append(options, otlptracegrpc.WithInsecure())
+	}
+	if *cfg.Compress {
+		options = append(options, otlptracegrpc.WithCompressor("gzip"))
+	}
+
+	// if unset, OTEL will use the default one automatically
+	if cfg.Endpoint != "" {
+		options = append(options, otlptracegrpc.WithEndpoint(cfg.Endpoint))
+	}
+
+	if len(cfg.Headers) > 0 {
+		options = append(options, otlptracegrpc.WithHeaders(cfg.Headers))
+	}
+
+	return options
+}
+
+func httpOptions(cfg *SignalExporter) []otlptracehttp.Option {
+	var options []otlptracehttp.Option
+	if *cfg.Insecure {
+		options = append(options, otlptracehttp.WithInsecure())
+	}
+	if *cfg.Compress {
+		options = append(options, otlptracehttp.WithCompression(otlptracehttp.GzipCompression))
+	}
+
+	if cfg.CustomURL != "" {
+		options = append(options, otlptracehttp.WithURLPath(cfg.CustomURL))
+	}
+
+	// if unset, OTEL will use the default one automatically
+	if cfg.Endpoint != "" {
+		options = append(options, otlptracehttp.WithEndpoint(cfg.Endpoint))
+	}
+
+	if len(cfg.Headers) > 0 {
+		options = append(options, otlptracehttp.WithHeaders(cfg.Headers))
+	}
+
+	return options
+}
+
+// fanoutProcessor passes the spans to all the processors, used as the single next processor of the tail sampler
+type fanoutProcessor []sdktrace.SpanProcessor
+
+func (fp fanoutProcessor) OnStart(parent context.Context, s sdktrace.ReadWriteSpan) {
+	for _, p := range fp {
+		p.OnStart(parent, s)
+	}
+}
+
+func (fp fanoutProcessor) OnEnd(s sdktrace.ReadOnlySpan) {
+	for _, p := range fp {
+		p.OnEnd(s)
+	}
+}
+
+func (fp fanoutProcessor) Shutdown(ctx context.Context) error {
+	var errs []error
+	for _, p := range fp {
+		if err := p.Shutdown(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return stdErrors.Join(errs...)
+}
+
+func (fp fanoutProcessor) ForceFlush(ctx context.Context) error {
+	var errs []error
+	for _, p := range fp {
+		if err := p.ForceFlush(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return stdErrors.Join(errs...)
+}
@@ -0,0 +1,295 @@
+package otel
+
+import (
+	"compress/gzip"
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/roadrunner-server/errors"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+)
+
+type FsyncPolicy string
+
+const (
+	// the OS decides when the data is written to the disk
+	fsyncNever FsyncPolicy = "never"
+	// after every batch
+	fsyncBatch FsyncPolicy = "batch"
+	// at most once per the FsyncInterval
+	fsyncInterval FsyncPolicy = "interval"
+)
+
+const backupTimeFormat = "2006-01-02T15-04-05.000000000"
+
+// File configures the file exporter writing the span batches as the OTLP JSON lines
+type File struct {
+	// Path of the file. Defaults to rr-traces.jsonl
+	Path string `mapstructure:"path"`
+	// MaxSize of the file in megabytes before it is rotated. Defaults to 100
+	MaxSize int `mapstructure:"max_size"`
+	// MaxAge of the file before it is rotated, not rotated by age if empty
+	MaxAge time.Duration `mapstructure:"max_age"`
+	// MaxBackups is the number of the rotated files kept, all of them are kept if 0
+	MaxBackups int `mapstructure:"max_backups"`
+	// Compress the rotated files with gzip
+	Compress bool `mapstructure:"compress"`
+	// Fsync policy: never, batch or interval. Defaults to never
+	Fsync FsyncPolicy `mapstructure:"fsync"`
+	// FsyncInterval used by the interval policy. Defaults to 1s
+	FsyncInterval time.Duration `mapstructure:"fsync_interval"`
+}
+
+func (f *File) initDefault(log *slog.Logger) {
+	if f.Path == "" {
+		f.Path = "rr-traces.jsonl"
+	}
+	if f.MaxSize <= 0 {
+		f.MaxSize = 100
+	}
+	if f.MaxBackups < 0 {
+		f.MaxBackups = 0
+	}
+
+	switch f.Fsync {
+	case fsyncNever, fsyncBatch, fsyncInterval:
+		// ok value, do nothing
+	case "":
+		f.Fsync = fsyncNever
+	default:
+		log.Warn("unknown fsync policy", "fsync", string(f.Fsync))
+		f.Fsync = fsyncNever
+	}
+
+	if f.FsyncInterval <= 0 {
+		f.FsyncInterval = time.Second
+	}
+}
+
+// fileClient is the otlptrace.Client appending the span batches to the rotated file, one ExportTraceServiceRequest per line
+type fileClient struct {
+	cfg *File
+
+	mu       sync.Mutex
+	file     *os.File
+	size     int64
+	openedAt time.Time
+	syncedAt time.Time
+}
+
+func newFileClient(cfg *File) *fileClient {
+	return &fileClient{cfg: cfg}
+}
+
+func (c *fileClient) Start(context.Context) error {
+	const op = errors.Op("otel_file_client_start")
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(c.cfg.Path), 0o755); err != nil {
+		return errors.E(op, err)
+	}
+	if err := c.open(); err != nil {
+		return errors.E(op, err)
+	}
+	return nil
+}
+
+func (c *fileClient) Stop(context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.file == nil {
+		return nil
+	}
+
+	err := c.file.Sync()
+	if errC := c.file.Close(); errC != nil && err == nil {
+		err = errC
+	}
+	c.file = nil
+	return err
+}
+
+func (c *fileClient) UploadTraces(_ context.Context, protoSpans []*tracepb.ResourceSpans) error {
+	const op = errors.Op("otel_file_client_upload")
+
+	line, err := marshalTracesJSON(protoSpans)
+	if err != nil {
+		return errors.E(op, err)
+	}
+	line = append(line, '\n')
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.file == nil {
+		return errors.E(op, errors.Str("file exporter is stopped"))
+	}
+
+	var errRotate error
+	if c.shouldRotate(int64(len(line))) {
+		errRotate = c.rotate()
+		// keep writing to the current path, the batch is not lost
+		if c.file == nil {
+			if err = c.open(); err != nil {
+				return errors.E(op, errors.Errorf("rotate: %v, reopen: %v", errRotate, err))
+			}
+		}
+	}
+
+	n, err := c.file.Write(line)
+	c.size += int64(n)
+	if err != nil {
+		return errors.E(op, err)
+	}
+
+	switch c.cfg.Fsync { //nolint:exhaustive
+	case fsyncBatch:
+		err = c.file.Sync()
+	case fsyncInterval:
+		if time.Since(c.syncedAt) >= c.cfg.FsyncInterval {
+			err = c.file.Sync()
+			c.syncedAt = time.Now()
+		}
+	}
+	if err != nil {
+		return errors.E(op, err)
+	}
+	if errRotate != nil {
+		return errors.E(op, errRotate)
+	}
+
+	return nil
+}
+
+func (c *fileClient) open() error {
+	file, err := os.OpenFile(c.cfg.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+
+	c.file = file
+	c.size = info.Size()
+	c.openedAt = time.Now()
+	c.syncedAt = c.openedAt
+	return nil
+}
+
+func (c *fileClient) shouldRotate(n int64) bool {
+	if c.size == 0 {
+		return false
+	}
+	if c.size+n > int64(c.cfg.MaxSize)*1024*1024 {
+		return true
+	}
+	return c.cfg.MaxAge > 0 && time.Since(c.openedAt) >= c.cfg.MaxAge
+}
+
+// rotate renames the current file to the timestamped backup, optionally compresses it and removes the oldest backups
+func (c *fileClient) rotate() error {
+	if err := c.file.Sync(); err != nil {
+		return err
+	}
+	if err := c.file.Close(); err != nil {
+		return err
+	}
+	c.file = nil
+
+	prefix, ext := c.backupName()
+	backup := prefix + time.Now().UTC().Format(backupTimeFormat) + ext
+	if err := os.Rename(c.cfg.Path, backup); err != nil {
+		return err
+	}
+
+	if c.cfg.Compress {
+		if err := gzipFile(backup); err != nil {
+			return err
+		}
+	}
+
+	if err := c.open(); err != nil {
+		return err
+	}
+
+	return c.removeBackups()
+}
+
+// backupName returns the prefix and the extension of the backups, e.g. /var/log/rr-traces- and .jsonl
+func (c *fileClient) backupName() (string, string) {
+	ext := filepath.Ext(c.cfg.Path)
+	return strings.TrimSuffix(c.cfg.Path, ext) + "-", ext
+}
+
+func (c *fileClient) removeBackups() error {
+	if c.cfg.MaxBackups == 0 {
+		return nil
+	}
+
+	prefix, ext := c.backupName()
+	backups, err := filepath.Glob(prefix + "*" + ext + "*")
+	if err != nil {
+		return err
+	}
+	backups = slices.DeleteFunc(backups, func(name string) bool {
+		stamp := strings.TrimSuffix(strings.TrimSuffix(strings.TrimPrefix(name, prefix), ".gz"), ext)
+		_, errP := time.Parse(backupTimeFormat, stamp)
+		return errP != nil
+	})
+	if len(backups) <= c.cfg.MaxBackups {
+		return nil
+	}
+
+	// the timestamps sort in the chronological order
+	slices.Sort(backups)
+	for _, name := range backups[:len(backups)-c.cfg.MaxBackups] {
+		if err = os.Remove(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// gzipFile replaces the file with its name.gz compressed copy
+func gzipFile(name string) error {
+	src, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = src.Close()
+	}()
+
+	dst, err := os.OpenFile(name+".gz", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+
+	gz := gzip.NewWriter(dst)
+	if _, err = io.Copy(gz, src); err != nil {
+		_ = dst.Close()
+		return err
+	}
+	if err = gz.Close(); err != nil {
+		_ = dst.Close()
+		return err
+	}
+	if err = dst.Close(); err != nil {
+		return err
+	}
+
+	return os.Remove(name)
+}
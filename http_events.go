@@ -0,0 +1,126 @@
+package otel
+
+import (
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/felixge/httpsnoop"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type MessageEvents string
+
+const (
+	// an event on every body read and write
+	messageEventsAll MessageEvents = "all"
+	// no message events
+	messageEventsNone MessageEvents = "none"
+	// the totals recorded as the span attributes at the span end
+	messageEventsAggregate MessageEvents = "aggregate"
+)
+
+const (
+	readBytesKey       = attribute.Key("http.read_bytes")
+	readDurationKey    = attribute.Key("http.read_duration")
+	wroteBytesKey      = attribute.Key("http.wrote_bytes")
+	writeDurationKey   = attribute.Key("http.write_duration")
+	timeToFirstByteKey = attribute.Key("http.time_to_first_byte")
+)
+
+// messageStats aggregates the request body reads and the response writes of a request,
+// both happen in the handler goroutine
+type messageStats struct {
+	start         time.Time
+	readBytes     int64
+	readDuration  time.Duration
+	wroteBytes    int64
+	writeDuration time.Duration
+	firstByte     time.Duration
+}
+
+func newMessageStats() *messageStats {
+	return &messageStats{start: time.Now()}
+}
+
+// wrap counts the request body reads and the response writes
+func (ms *messageStats) wrap(w http.ResponseWriter, r *http.Request) (http.ResponseWriter, *http.Request) {
+	if r.Body != nil && r.Body != http.NoBody {
+		r.Body = &countingBody{ReadCloser: r.Body, stats: ms}
+	}
+
+	return httpsnoop.Wrap(w, httpsnoop.Hooks{
+		WriteHeader: func(next httpsnoop.WriteHeaderFunc) httpsnoop.WriteHeaderFunc {
+			return func(code int) {
+				ms.firstWrite()
+				next(code)
+			}
+		},
+		Write: func(next httpsnoop.WriteFunc) httpsnoop.WriteFunc {
+			return func(b []byte) (int, error) {
+				ms.firstWrite()
+				start := time.Now()
+				n, err := next(b)
+				ms.wrote(int64(n), start)
+				return n, err
+			}
+		},
+		WriteString: func(next httpsnoop.WriteStringFunc) httpsnoop.WriteStringFunc {
+			return func(s string) (int, error) {
+				ms.firstWrite()
+				start := time.Now()
+				n, err := next(s)
+				ms.wrote(int64(n), start)
+				return n, err
+			}
+		},
+		ReadFrom: func(next httpsnoop.ReadFromFunc) httpsnoop.ReadFromFunc {
+			return func(src io.Reader) (int64, error) {
+				ms.firstWrite()
+				start := time.Now()
+				n, err := next(src)
+				ms.wrote(n, start)
+				return n, err
+			}
+		},
+	}), r
+}
+
+func (ms *messageStats) firstWrite() {
+	if ms.firstByte == 0 {
+		ms.firstByte = time.Since(ms.start)
+	}
+}
+
+func (ms *messageStats) wrote(n int64, start time.Time) {
+	ms.wroteBytes += n
+	ms.writeDuration += time.Since(start)
+}
+
+// record sets the totals on the span, the durations are in seconds
+func (ms *messageStats) record(span trace.Span) {
+	attrs := []attribute.KeyValue{
+		readBytesKey.Int64(ms.readBytes),
+		readDurationKey.Float64(ms.readDuration.Seconds()),
+		wroteBytesKey.Int64(ms.wroteBytes),
+		writeDurationKey.Float64(ms.writeDuration.Seconds()),
+	}
+	if ms.firstByte > 0 {
+		attrs = append(attrs, timeToFirstByteKey.Float64(ms.firstByte.Seconds()))
+	}
+	span.SetAttributes(attrs...)
+}
+
+type countingBody struct {
+	io.ReadCloser
+	stats *messageStats
+}
+
+func (cb *countingBody) Read(p []byte) (int, error) {
+	start := time.Now()
+	n, err := cb.ReadCloser.Read(p)
+	cb.stats.readBytes += int64(n)
+	cb.stats.readDuration += time.Since(start)
+	return n, err
+}
@@ -0,0 +1,102 @@
+package otel
+
+import (
+	"context"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/roadrunner-server/errors"
+)
+
+// Exclude lists the requests served without spans and metrics. The incoming trace context is passed to the worker unchanged,
+// unless the request is not trusted (see Trust).
+type Exclude struct {
+	// Paths are matched exactly, e.g. /health
+	Paths []string `mapstructure:"paths"`
+	// Prefixes of the paths, e.g. /static/
+	Prefixes []string `mapstructure:"prefixes"`
+	// Globs are matched against the path with the path.Match syntax, e.g. /*.ico
+	Globs []string `mapstructure:"globs"`
+	// Methods, case-insensitive, e.g. OPTIONS
+	Methods []string `mapstructure:"methods"`
+	// UserAgents are matched as case-insensitive substrings of the User-Agent header, e.g. kube-probe
+	UserAgents []string `mapstructure:"user_agents"`
+}
+
+type excludedKey struct{}
+
+type requestFilter struct {
+	paths      map[string]struct{}
+	prefixes   []string
+	globs      []string
+	methods    map[string]struct{}
+	userAgents []string
+}
+
+func newRequestFilter(cfg *Exclude) (*requestFilter, error) {
+	const op = errors.Op("otel_new_request_filter")
+
+	rf := &requestFilter{
+		paths:    make(map[string]struct{}, len(cfg.Paths)),
+		prefixes: cfg.Prefixes,
+		globs:    cfg.Globs,
+		methods:  make(map[string]struct{}, len(cfg.Methods)),
+	}
+
+	for _, p := range cfg.Paths {
+		rf.paths[p] = struct{}{}
+	}
+	for _, glob := range cfg.Globs {
+		if _, err := path.Match(glob, ""); err != nil {
+			return nil, errors.E(op, errors.Errorf("invalid glob %q: %v", glob, err))
+		}
+	}
+	for _, method := range cfg.Methods {
+		rf.methods[strings.ToUpper(method)] = struct{}{}
+	}
+	for _, ua := range cfg.UserAgents {
+		rf.userAgents = append(rf.userAgents, strings.ToLower(ua))
+	}
+
+	return rf, nil
+}
+
+// excluded reports whether the request should be served without spans
+func (rf *requestFilter) excluded(r *http.Request) bool {
+	if _, ok := rf.methods[r.Method]; ok {
+		return true
+	}
+
+	p := r.URL.Path
+	if _, ok := rf.paths[p]; ok {
+		return true
+	}
+	for _, prefix := range rf.prefixes {
+		if strings.HasPrefix(p, prefix) {
+			return true
+		}
+	}
+	for _, glob := range rf.globs {
+		// the patterns are validated in newRequestFilter
+		if ok, _ := path.Match(glob, p); ok {
+			return true
+		}
+	}
+
+	if len(rf.userAgents) > 0 {
+		ua := strings.ToLower(r.UserAgent())
+		for _, sub := range rf.userAgents {
+			if strings.Contains(ua, sub) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func isExcluded(ctx context.Context) bool {
+	excluded, _ := ctx.Value(excludedKey{}).(bool)
+	return excluded
+}
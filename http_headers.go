@@ -0,0 +1,117 @@
+package otel
+
+import (
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const defaultHeaderMaxLength = 256
+
+// sensitiveHeaders are never captured, even if listed
+var sensitiveHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"}
+
+// CaptureHeaders lists the headers recorded as the http.request.header.<name> and http.response.header.<name> span attributes
+type CaptureHeaders struct {
+	// Request headers, case-insensitive, e.g. X-Tenant-ID
+	Request []string `mapstructure:"request"`
+	// Response headers, case-insensitive, e.g. Content-Type
+	Response []string `mapstructure:"response"`
+	// MaxLength of a captured value in bytes, longer values are truncated. Defaults to 256
+	MaxLength int `mapstructure:"max_length"`
+}
+
+func (ch *CaptureHeaders) initDefault(log *slog.Logger) {
+	if ch.MaxLength <= 0 {
+		ch.MaxLength = defaultHeaderMaxLength
+	}
+
+	for _, name := range slices.Concat(ch.Request, ch.Response) {
+		if isSensitiveHeader(name) {
+			log.Warn("sensitive headers are never captured", "header", name)
+		}
+	}
+}
+
+type headerCapture struct {
+	request   []capturedHeader
+	response  []capturedHeader
+	maxLength int
+}
+
+type capturedHeader struct {
+	// canonical header name
+	name string
+	key  attribute.Key
+}
+
+func newHeaderCapture(cfg *CaptureHeaders) *headerCapture {
+	return &headerCapture{
+		request:   compileHeaders(cfg.Request, "http.request.header."),
+		response:  compileHeaders(cfg.Response, "http.response.header."),
+		maxLength: cfg.MaxLength,
+	}
+}
+
+func compileHeaders(names []string, prefix string) []capturedHeader {
+	headers := make([]capturedHeader, 0, len(names))
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" || isSensitiveHeader(name) {
+			continue
+		}
+		headers = append(headers, capturedHeader{
+			name: http.CanonicalHeaderKey(name),
+			key:  attribute.Key(prefix + strings.ToLower(name)),
+		})
+	}
+	return headers
+}
+
+func isSensitiveHeader(name string) bool {
+	return slices.Contains(sensitiveHeaders, http.CanonicalHeaderKey(strings.TrimSpace(name)))
+}
+
+// captureRequest records the request headers on the span
+func (hc *headerCapture) captureRequest(span trace.Span, header http.Header) {
+	hc.capture(span, header, hc.request)
+}
+
+// responseHook records the response headers on the span
+func (hc *headerCapture) responseHook(span trace.Span) headersHook {
+	return func(header http.Header, _ int) {
+		hc.capture(span, header, hc.response)
+	}
+}
+
+func (hc *headerCapture) capture(span trace.Span, header http.Header, headers []capturedHeader) {
+	for _, h := range headers {
+		values := header[h.name]
+		if len(values) == 0 {
+			continue
+		}
+
+		truncated := make([]string, len(values))
+		for i, v := range values {
+			truncated[i] = truncate(v, hc.maxLength)
+		}
+		// https://opentelemetry.io/docs/specs/semconv/http/http-spans/#http-server-span
+		span.SetAttributes(h.key.StringSlice(truncated))
+	}
+}
+
+// truncate cuts the value to at most n bytes, keeping the UTF-8 sequences whole
+func truncate(v string, n int) string {
+	if len(v) <= n {
+		return v
+	}
+	for n > 0 && !utf8.RuneStart(v[n]) {
+		n--
+	}
+	return v[:n]
+}
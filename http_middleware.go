@@ -2,37 +2,315 @@ package otel
 
 import (
 	"context"
+	"log/slog"
 	"net/http"
 
 	rrcontext "github.com/roadrunner-server/context"
+	"github.com/roadrunner-server/errors"
 	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/contrib/propagators/autoprop"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
 	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.43.0"
+	"go.opentelemetry.io/otel/semconv/v1.43.0/httpconv"
 	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
 )
 
+const (
+	scopeName = "github.com/roadrunner-server/otel"
+)
+
+// HTTP configures the HTTP middleware
+type HTTP struct {
+	// Routes are matched in order against the requests, the template of the first matching route is used in the span name
+	// and the http.route attribute. When routes are configured, the span name of the requests not matching any route is
+	// the HTTP method. Without routes, the span name is the request URI.
+	Routes []*Route `mapstructure:"routes"`
+	// WorkerHeaders applies the reserved X-RR-Otel-* response headers set by the worker to the server span
+	// (route, status and attributes) and removes them from the response
+	WorkerHeaders bool `mapstructure:"worker_headers"`
+	// Inject lists the propagators used to pass the server span context to the worker in the request headers,
+	// e.g. only tracecontext for a PHP SDK not aware of the jaeger format. Defaults to the top-level propagators
+	Inject []string `mapstructure:"inject"`
+	// Trust limits the sources allowed to continue their trace, all the requests are trusted if not set
+	Trust *Trust `mapstructure:"trust"`
+	// Exclude lists the requests served without spans, e.g. health checks and static assets
+	Exclude *Exclude `mapstructure:"exclude"`
+	// CaptureHeaders lists the request and response headers recorded as the span attributes
+	CaptureHeaders *CaptureHeaders `mapstructure:"capture_headers"`
+	// TraceResponse returns the trace context to the clients in the response headers
+	TraceResponse *TraceResponse `mapstructure:"trace_response"`
+	// Redact removes the sensitive data from the URL in the span names and attributes
+	Redact *Redact `mapstructure:"redact"`
+	// TrustedProxies are the CIDRs of the proxies allowed to report the original client address and scheme
+	// in the Forwarded, X-Forwarded-For, X-Real-IP and X-Forwarded-Proto headers
+	TrustedProxies []string `mapstructure:"trusted_proxies"`
+	// MessageEvents of the server span: all (an event on every body read and write), none or aggregate
+	// (the totals recorded as the span attributes at the span end). Defaults to all
+	MessageEvents MessageEvents `mapstructure:"message_events"`
+	// Recovery recovers the panics of the downstream handlers and records them on the server span
+	Recovery *Recovery `mapstructure:"recovery"`
+}
+
+func (h *HTTP) initDefault(log *slog.Logger) {
+	switch h.MessageEvents {
+	case messageEventsAll, messageEventsNone, messageEventsAggregate:
+		// ok value, do nothing
+	case "":
+		h.MessageEvents = messageEventsAll
+	default:
+		log.Warn("unknown message events mode", "mode", string(h.MessageEvents))
+		h.MessageEvents = messageEventsAll
+	}
+
+	if h.CaptureHeaders != nil {
+		h.CaptureHeaders.initDefault(log)
+	}
+}
+
 // type alias for the middleware
 type httpMiddleware func(http.Handler) http.Handler
 
-func httpWrapper(prop propagation.TextMapPropagator, tr trace.TracerProvider, sn string) httpMiddleware {
+// flush exports the ended spans on a repanic, it's nil with the tail sampling
+func httpWrapper(cfg *HTTP, prop propagation.TextMapPropagator, tr trace.TracerProvider, mp metric.MeterProvider, flush func(context.Context) error, sn string) (httpMiddleware, error) {
+	const op = errors.Op("otel_http_wrapper")
+	activeRequests, err := httpconv.NewServerActiveRequests(mp.Meter(scopeName))
+	if err != nil {
+		return nil, err
+	}
+
+	var routes *routeMatcher
+	if len(cfg.Routes) > 0 {
+		routes, err = newRouteMatcher(cfg.Routes)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// propagators used towards the worker
+	injector := prop
+	if len(cfg.Inject) > 0 {
+		injector, err = autoprop.TextMapPropagator(cfg.Inject...)
+		if err != nil {
+			return nil, errors.E(op, err)
+		}
+	}
+
+	var trust *trustChecker
+	if cfg.Trust != nil {
+		trust, err = newTrustChecker(cfg.Trust)
+		if err != nil {
+			return nil, errors.E(op, err)
+		}
+	}
+
+	var filter *requestFilter
+	if cfg.Exclude != nil {
+		filter, err = newRequestFilter(cfg.Exclude)
+		if err != nil {
+			return nil, errors.E(op, err)
+		}
+	}
+
+	var headers *headerCapture
+	if cfg.CaptureHeaders != nil {
+		headers = newHeaderCapture(cfg.CaptureHeaders)
+	}
+
+	var redactor *urlRedactor
+	if cfg.Redact != nil {
+		redactor, err = newURLRedactor(cfg.Redact)
+		if err != nil {
+			return nil, errors.E(op, err)
+		}
+	}
+
+	var proxies *proxyResolver
+	if len(cfg.TrustedProxies) > 0 {
+		proxies, err = newProxyResolver(cfg.TrustedProxies)
+		if err != nil {
+			return nil, errors.E(op, err)
+		}
+	}
+
+	fields := append(prop.Fields(), injector.Fields()...)
+
 	return func(h http.Handler) http.Handler {
-		// init otelhttp handler only once
-		handler := otelhttp.NewHandler(h, "",
+		// runs inside the server span
+		inner := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// filtered out by otelhttp, no span to propagate
+			if isExcluded(r.Context()) {
+				finish := func() {}
+				if cfg.WorkerHeaders {
+					// the reserved headers are still not sent to the client
+					w, finish = wrapResponseWriter(w, workerHeadersHook(r, noop.Span{}))
+				}
+				h.ServeHTTP(w, r)
+				finish()
+				return
+			}
+
+			span := trace.SpanFromContext(r.Context())
+			if sc, ok := untrustedFromContext(r.Context()); ok {
+				span.AddLink(trace.Link{SpanContext: sc})
+			}
+			if route := routeFromContext(r.Context()); route != "" {
+				span.SetAttributes(semconv.HTTPRoute(route))
+				labeler, _ := otelhttp.LabelerFromContext(r.Context())
+				labeler.Add(semconv.HTTPRoute(route))
+			}
+
+			if c, ok := clientFromContext(r.Context()); ok {
+				// replace the values set by otelhttp, which trusts X-Forwarded-For from any peer
+				span.SetAttributes(semconv.ClientAddress(c.address), semconv.URLScheme(c.scheme))
+			}
+			if redactor != nil {
+				// replaces the url.path set by otelhttp
+				span.SetAttributes(semconv.URLPath(redactor.path(r.URL)))
+				if query, ok := redactor.query(r.URL); ok {
+					span.SetAttributes(semconv.URLQuery(query))
+				}
+			}
+			if headers != nil {
+				headers.captureRequest(span, r.Header)
+			}
+
+			// pass the server span (not the upstream one) to the worker as the parent,
+			// the upstream headers are removed, so the worker sees only the injected formats
+			for _, field := range fields {
+				r.Header.Del(field)
+			}
+			injector.Inject(r.Context(), propagation.HeaderCarrier(r.Header))
+
+			var hooks []headersHook
+			if cfg.WorkerHeaders {
+				hooks = append(hooks, workerHeadersHook(r, span))
+			}
+			if cfg.TraceResponse != nil {
+				hooks = append(hooks, traceResponseHook(cfg.TraceResponse, span))
+			}
+			if headers != nil {
+				hooks = append(hooks, headers.responseHook(span))
+			}
+
+			if cfg.Recovery != nil {
+				var sent bool
+				hooks = append(hooks, func(http.Header, int) { sent = true })
+				defer func() {
+					rec := recover()
+					if rec == nil {
+						return
+					}
+					// the aborted requests are not the failures
+					if rec == http.ErrAbortHandler { //nolint:errorlint
+						panic(rec)
+					}
+
+					recordPanic(span, rec)
+					if cfg.Recovery.Repanic {
+						endAndFlush(span, flush)
+						panic(rec)
+					}
+					if !sent {
+						http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+					}
+				}()
+			}
+
+			w, finish := wrapResponseWriter(w, hooks...)
+			if cfg.MessageEvents == messageEventsAggregate {
+				stats := newMessageStats()
+				defer stats.record(span)
+				w, r = stats.wrap(w, r)
+			}
+
+			h.ServeHTTP(w, r)
+			// the handler wrote nothing, the hooks still have to run
+			finish()
+		})
+
+		opts := []otelhttp.Option{
 			otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
-				return r.RequestURI
+				if routes == nil {
+					if redactor != nil {
+						return redactor.requestURI(r.URL)
+					}
+					return r.RequestURI
+				}
+				return spanName(r, routeFromContext(r.Context()))
 			}),
 			otelhttp.WithSpanOptions(
 				trace.WithSpanKind(trace.SpanKindServer),
 			),
+			otelhttp.WithFilter(func(r *http.Request) bool {
+				return !isExcluded(r.Context())
+			}),
 			otelhttp.WithPropagators(prop),
 			otelhttp.WithTracerProvider(tr),
-			otelhttp.WithMessageEvents(otelhttp.ReadEvents, otelhttp.WriteEvents))
+			otelhttp.WithMeterProvider(mp),
+			otelhttp.WithMetricAttributesFn(func(r *http.Request) []attribute.KeyValue {
+				return []attribute.KeyValue{semconv.URLScheme(scheme(r.Context(), r))}
+			}),
+		}
+		if cfg.MessageEvents == messageEventsAll {
+			opts = append(opts, otelhttp.WithMessageEvents(otelhttp.ReadEvents, otelhttp.WriteEvents))
+		}
+
+		// init otelhttp handler only once
+		handler := otelhttp.NewHandler(inner, "", opts...)
 
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			ctx := context.WithValue(r.Context(), rrcontext.OtelTracerNameKey, sn)
-			// have effect only if the span started outside
-			// if the OTEL middleware is the first in the line, has no effect (we haven't yet started a span)
-			prop.Inject(ctx, propagation.HeaderCarrier(r.Header))
+
+			// the untrusted requests start a new trace, checked for the excluded requests as well,
+			// so neither the trust header nor the untrusted baggage reach the worker
+			if trust != nil && !trust.trusted(r) {
+				ctx = untrust(ctx, r, prop)
+			}
+
+			if filter != nil && filter.excluded(r) {
+				handler.ServeHTTP(w, r.WithContext(context.WithValue(ctx, excludedKey{}, true)))
+				return
+			}
+
+			if routes != nil {
+				if route := routes.match(r); route != "" {
+					ctx = withRoute(ctx, route)
+				}
+			}
+
+			if proxies != nil {
+				ctx = withClient(ctx, proxies.resolve(r))
+			}
+
+			method, sch := requestMethod(r.Method), scheme(ctx, r)
+			activeRequests.Add(ctx, 1, method, sch)
+			defer activeRequests.Add(ctx, -1, method, sch)
+
 			handler.ServeHTTP(w, r.WithContext(ctx))
 		})
+	}, nil
+}
+
+func scheme(ctx context.Context, r *http.Request) string {
+	if c, ok := clientFromContext(ctx); ok {
+		return c.scheme
+	}
+	if r.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// requestMethod returns the well-known HTTP method or _OTHER, to keep the metrics cardinality bounded
+func requestMethod(method string) httpconv.RequestMethodAttr {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace:
+		return httpconv.RequestMethodAttr(method)
+	default:
+		return httpconv.RequestMethodOther
 	}
 }
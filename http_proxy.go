@@ -0,0 +1,139 @@
+package otel
+
+import (
+	"context"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+type clientKey struct{}
+
+// client is the original client address and scheme resolved from the forwarding headers of the trusted proxies
+type client struct {
+	address string
+	scheme  string
+}
+
+// proxyResolver resolves the original client behind the trusted proxies from the Forwarded,
+// X-Forwarded-For, X-Real-IP and X-Forwarded-Proto headers, ignored when the peer is not a trusted proxy
+type proxyResolver struct {
+	prefixes []netip.Prefix
+}
+
+func newProxyResolver(cidrs []string) (*proxyResolver, error) {
+	prefixes, err := parsePrefixes(cidrs)
+	if err != nil {
+		return nil, err
+	}
+	return &proxyResolver{prefixes: prefixes}, nil
+}
+
+func (pr *proxyResolver) resolve(r *http.Request) client {
+	peer := splitPeer(r.RemoteAddr)
+	c := client{address: peer, scheme: "http"}
+	if r.TLS != nil {
+		c.scheme = "https"
+	}
+
+	if !pr.trusted(peer) {
+		return c
+	}
+
+	// https://www.rfc-editor.org/rfc/rfc7239
+	if forwarded := r.Header.Values("Forwarded"); len(forwarded) > 0 {
+		var hops []string
+		var protos []string
+		for _, value := range forwarded {
+			for element := range strings.SplitSeq(value, ",") {
+				forValue, proto := parseForwarded(element)
+				hops = append(hops, forValue)
+				protos = append(protos, proto)
+			}
+		}
+		if i := pr.clientHop(hops); i >= 0 {
+			c.address = hops[i]
+			if protos[i] != "" {
+				c.scheme = strings.ToLower(protos[i])
+			}
+		}
+		return c
+	}
+
+	if xff := r.Header.Values("X-Forwarded-For"); len(xff) > 0 {
+		var hops []string
+		for _, value := range xff {
+			for hop := range strings.SplitSeq(value, ",") {
+				hops = append(hops, strings.TrimSpace(hop))
+			}
+		}
+		if i := pr.clientHop(hops); i >= 0 {
+			c.address = splitPeer(hops[i])
+		}
+	} else if realIP := strings.TrimSpace(r.Header.Get("X-Real-IP")); realIP != "" {
+		c.address = realIP
+	}
+
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+		// the first value is set by the proxy closest to the client
+		first, _, _ := strings.Cut(proto, ",")
+		c.scheme = strings.ToLower(strings.TrimSpace(first))
+	}
+
+	return c
+}
+
+// clientHop walks the hops from the closest one and returns the index of the first not trusted proxy,
+// or of the farthest hop when all of them are trusted
+func (pr *proxyResolver) clientHop(hops []string) int {
+	for i := len(hops) - 1; i >= 0; i-- {
+		if hops[i] == "" {
+			continue
+		}
+		if i == 0 || !pr.trusted(hops[i]) {
+			return i
+		}
+	}
+	return -1
+}
+
+func (pr *proxyResolver) trusted(hop string) bool {
+	addr, ok := parseAddr(hop)
+	return ok && containsAddr(pr.prefixes, addr)
+}
+
+// parseForwarded returns the for and proto parameters of the Forwarded header element, e.g. for="[2001:db8::1]:4711";proto=https
+func parseForwarded(element string) (string, string) {
+	var forValue, proto string
+	for pair := range strings.SplitSeq(element, ";") {
+		name, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok {
+			continue
+		}
+		value = strings.Trim(value, `"`)
+		switch strings.ToLower(name) {
+		case "for":
+			forValue = splitPeer(value)
+		case "proto":
+			proto = value
+		}
+	}
+	return forValue, proto
+}
+
+// splitPeer removes the port and the IPv6 brackets from the address
+func splitPeer(hostport string) string {
+	if addr, ok := parseAddr(hostport); ok {
+		return addr.String()
+	}
+	return hostport
+}
+
+func withClient(ctx context.Context, c client) context.Context {
+	return context.WithValue(ctx, clientKey{}, c)
+}
+
+func clientFromContext(ctx context.Context) (client, bool) {
+	c, ok := ctx.Value(clientKey{}).(client)
+	return c, ok
+}
@@ -0,0 +1,43 @@
+package otel
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"time"
+
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.43.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const flushTimeout = 5 * time.Second
+
+// Recovery recovers the panics of the downstream handlers, recording them on the server span
+type Recovery struct {
+	// Repanic ends and flushes the span and panics again, otherwise the 500 response is sent (if the headers are not sent yet)
+	Repanic bool `mapstructure:"repanic"`
+}
+
+// recordPanic adds the exception event with the stack trace and sets the error status,
+// must be called from the deferred function to capture the panicking stack
+func recordPanic(span trace.Span, rec any) {
+	message := fmt.Sprint(rec)
+	span.AddEvent(semconv.ExceptionEventName, trace.WithAttributes(
+		semconv.ExceptionType(fmt.Sprintf("%T", rec)),
+		semconv.ExceptionMessage(message),
+		semconv.ExceptionStacktrace(string(debug.Stack())),
+	))
+	span.SetStatus(codes.Error, message)
+}
+
+// endAndFlush ends the span and exports it before the panic propagates further. The flush is nil
+// when the spans are buffered by the tail sampler: the trace is decided later, once it's complete.
+func endAndFlush(span trace.Span, flush func(context.Context) error) {
+	span.End()
+	if flush != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), flushTimeout)
+		defer cancel()
+		_ = flush(ctx)
+	}
+}
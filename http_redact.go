@@ -0,0 +1,113 @@
+package otel
+
+import (
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/roadrunner-server/errors"
+)
+
+const redacted = "REDACTED"
+
+// Redact removes the sensitive data (tokens, emails, signatures) from the URL in the span names and the url.* attributes.
+// A query parameter value is redacted when the parameter is in Deny, or when Allow is set and the parameter is not in it.
+type Redact struct {
+	// DropQuery removes the query string entirely
+	DropQuery bool `mapstructure:"drop_query"`
+	// Allow lists the query parameters kept as is, case-insensitive
+	Allow []string `mapstructure:"allow"`
+	// Deny lists the query parameters with the redacted values, case-insensitive
+	Deny []string `mapstructure:"deny"`
+	// Patterns are regular expressions masked in the path and the query, e.g. [^/@]+@[^/]+ for the emails
+	Patterns []string `mapstructure:"patterns"`
+}
+
+type urlRedactor struct {
+	dropQuery bool
+	allow     []string
+	deny      []string
+	patterns  []*regexp.Regexp
+}
+
+func newURLRedactor(cfg *Redact) (*urlRedactor, error) {
+	const op = errors.Op("otel_new_url_redactor")
+
+	ur := &urlRedactor{
+		dropQuery: cfg.DropQuery,
+		allow:     lower(cfg.Allow),
+		deny:      lower(cfg.Deny),
+		patterns:  make([]*regexp.Regexp, 0, len(cfg.Patterns)),
+	}
+
+	for _, pattern := range cfg.Patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, errors.E(op, errors.Errorf("invalid redaction pattern %q: %v", pattern, err))
+		}
+		ur.patterns = append(ur.patterns, re)
+	}
+
+	return ur, nil
+}
+
+func lower(names []string) []string {
+	out := make([]string, 0, len(names))
+	for _, name := range names {
+		out = append(out, strings.ToLower(name))
+	}
+	return out
+}
+
+// requestURI returns the redacted escaped path with the query, used in the span name
+func (ur *urlRedactor) requestURI(u *url.URL) string {
+	uri := ur.mask(u.EscapedPath())
+	if query, ok := ur.query(u); ok {
+		uri += "?" + query
+	}
+	return uri
+}
+
+// path returns the path with the patterns masked, used in the url.path attribute
+func (ur *urlRedactor) path(u *url.URL) string {
+	return ur.mask(u.Path)
+}
+
+// query returns the redacted raw query, false if there is no query or it's dropped
+func (ur *urlRedactor) query(u *url.URL) (string, bool) {
+	if ur.dropQuery || u.RawQuery == "" {
+		return "", false
+	}
+
+	params := strings.Split(u.RawQuery, "&")
+	for i, param := range params {
+		name, _, hasValue := strings.Cut(param, "=")
+		if !hasValue {
+			continue
+		}
+		// the names are compared unescaped, e.g. access%5Ftoken
+		if unescaped, err := url.QueryUnescape(name); err == nil {
+			name = unescaped
+		}
+		if ur.redactParam(strings.ToLower(name)) {
+			params[i] = param[:strings.IndexByte(param, '=')+1] + redacted
+		}
+	}
+
+	return ur.mask(strings.Join(params, "&")), true
+}
+
+func (ur *urlRedactor) redactParam(name string) bool {
+	if slices.Contains(ur.deny, name) {
+		return true
+	}
+	return len(ur.allow) > 0 && !slices.Contains(ur.allow, name)
+}
+
+func (ur *urlRedactor) mask(s string) string {
+	for _, re := range ur.patterns {
+		s = re.ReplaceAllLiteralString(s, redacted)
+	}
+	return s
+}
@@ -0,0 +1,147 @@
+package otel
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/felixge/httpsnoop"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.43.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// canonical form of the X-RR-Otel- prefix
+	workerHeaderPrefix = "X-Rr-Otel-"
+	workerRouteHeader  = workerHeaderPrefix + "Route"
+	workerStatusHeader = workerHeaderPrefix + "Status"
+	workerAttrPrefix   = workerHeaderPrefix + "Attr-"
+)
+
+// headersHook is called once, right before the response headers are sent, and may modify them
+type headersHook func(header http.Header, status int)
+
+// wrapResponseWriter runs the hooks before the response headers are sent, keeping the optional
+// interfaces (http.Flusher, http.Hijacker, io.ReaderFrom, etc.) of the original writer. The returned
+// finish function must be called once the handler returns: when nothing was written, net/http sends
+// the headers itself, bypassing the wrapper, so finish sends them through it.
+func wrapResponseWriter(w http.ResponseWriter, hooks ...headersHook) (http.ResponseWriter, func()) {
+	if len(hooks) == 0 {
+		return w, func() {}
+	}
+
+	var sent, hijacked bool
+	before := func(status int) {
+		if sent {
+			return
+		}
+		sent = true
+		for _, hook := range hooks {
+			hook(w.Header(), status)
+		}
+	}
+
+	wrapped := httpsnoop.Wrap(w, httpsnoop.Hooks{
+		WriteHeader: func(next httpsnoop.WriteHeaderFunc) httpsnoop.WriteHeaderFunc {
+			return func(code int) {
+				// informational responses are followed by the final one
+				if code >= 100 && code < 200 && code != http.StatusSwitchingProtocols {
+					next(code)
+					return
+				}
+				before(code)
+				next(code)
+			}
+		},
+		Write: func(next httpsnoop.WriteFunc) httpsnoop.WriteFunc {
+			return func(b []byte) (int, error) {
+				before(http.StatusOK)
+				return next(b)
+			}
+		},
+		WriteString: func(next httpsnoop.WriteStringFunc) httpsnoop.WriteStringFunc {
+			return func(s string) (int, error) {
+				before(http.StatusOK)
+				return next(s)
+			}
+		},
+		ReadFrom: func(next httpsnoop.ReadFromFunc) httpsnoop.ReadFromFunc {
+			return func(src io.Reader) (int64, error) {
+				before(http.StatusOK)
+				return next(src)
+			}
+		},
+		Flush: func(next httpsnoop.FlushFunc) httpsnoop.FlushFunc {
+			return func() {
+				before(http.StatusOK)
+				next()
+			}
+		},
+		FlushError: func(next httpsnoop.FlushErrorFunc) httpsnoop.FlushErrorFunc {
+			return func() error {
+				before(http.StatusOK)
+				return next()
+			}
+		},
+		Hijack: func(next httpsnoop.HijackFunc) httpsnoop.HijackFunc {
+			return func() (net.Conn, *bufio.ReadWriter, error) {
+				hijacked = true
+				return next()
+			}
+		},
+	})
+
+	return wrapped, func() {
+		if !sent && !hijacked {
+			wrapped.WriteHeader(http.StatusOK)
+		}
+	}
+}
+
+// workerHeadersHook applies the reserved X-RR-Otel-* response headers set by the worker to the server span
+// and removes them from the response:
+//   - X-RR-Otel-Route: route template, renames the span and sets the http.route attribute
+//   - X-RR-Otel-Status: error, error:<description> or ok
+//   - X-RR-Otel-Attr-<name>: sets the <name> (lowercased) span attribute
+func workerHeadersHook(r *http.Request, span trace.Span) headersHook {
+	return func(header http.Header, _ int) {
+		for name, values := range header {
+			if !strings.HasPrefix(name, workerHeaderPrefix) {
+				continue
+			}
+			delete(header, name)
+
+			if len(values) == 0 || values[0] == "" {
+				continue
+			}
+
+			switch {
+			case name == workerRouteHeader:
+				span.SetName(spanName(r, values[0]))
+				span.SetAttributes(semconv.HTTPRoute(values[0]))
+				labeler, _ := otelhttp.LabelerFromContext(r.Context())
+				labeler.Add(semconv.HTTPRoute(values[0]))
+			case name == workerStatusHeader:
+				status, description, _ := strings.Cut(values[0], ":")
+				switch strings.ToLower(strings.TrimSpace(status)) {
+				case "error":
+					span.SetStatus(codes.Error, strings.TrimSpace(description))
+				case "ok":
+					span.SetStatus(codes.Ok, "")
+				}
+			case strings.HasPrefix(name, workerAttrPrefix) && len(name) > len(workerAttrPrefix):
+				key := attribute.Key(strings.ToLower(name[len(workerAttrPrefix):]))
+				if len(values) == 1 {
+					span.SetAttributes(key.String(values[0]))
+				} else {
+					span.SetAttributes(key.StringSlice(values))
+				}
+			}
+		}
+	}
+}
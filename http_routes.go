@@ -0,0 +1,128 @@
+package otel
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/roadrunner-server/errors"
+	"go.opentelemetry.io/otel/semconv/v1.43.0/httpconv"
+)
+
+// Route maps the request paths to a low-cardinality route template, used in the span name and
+// the http.route attribute. Either Pattern or Regex with Template must be set.
+type Route struct {
+	// Method limits the route to the HTTP method, case-insensitive
+	Method string `mapstructure:"method"`
+	// Pattern is a path template, e.g. /users/{id}. {name} matches a single path segment, {name...} the rest of the path
+	Pattern string `mapstructure:"pattern"`
+	// Regex matches the request path
+	Regex string `mapstructure:"regex"`
+	// Template is the route reported for the Regex matches
+	Template string `mapstructure:"template"`
+}
+
+type routeKey struct{}
+
+type compiledRoute struct {
+	method   string
+	re       *regexp.Regexp
+	template string
+}
+
+// routeMatcher returns the template of the first route matching the request
+type routeMatcher struct {
+	routes []compiledRoute
+}
+
+func newRouteMatcher(routes []*Route) (*routeMatcher, error) {
+	const op = errors.Op("otel_new_route_matcher")
+
+	rm := &routeMatcher{routes: make([]compiledRoute, 0, len(routes))}
+	for i, route := range routes {
+		cr := compiledRoute{method: strings.ToUpper(route.Method)}
+
+		var err error
+		switch {
+		case route.Pattern != "":
+			cr.template = route.Pattern
+			cr.re, err = patternToRegexp(route.Pattern)
+		case route.Regex != "" && route.Template != "":
+			cr.template = route.Template
+			cr.re, err = regexp.Compile(route.Regex)
+		default:
+			return nil, errors.E(op, errors.Errorf("route #%d: either pattern or regex with template should be set", i))
+		}
+		if err != nil {
+			return nil, errors.E(op, errors.Errorf("route #%d: %v", i, err))
+		}
+
+		rm.routes = append(rm.routes, cr)
+	}
+
+	return rm, nil
+}
+
+func (rm *routeMatcher) match(r *http.Request) string {
+	for i := range rm.routes {
+		if rm.routes[i].method != "" && rm.routes[i].method != r.Method {
+			continue
+		}
+		if rm.routes[i].re.MatchString(r.URL.Path) {
+			return rm.routes[i].template
+		}
+	}
+	return ""
+}
+
+// patternToRegexp converts the /users/{id}/files/{path...} pattern to the anchored regular expression
+func patternToRegexp(pattern string) (*regexp.Regexp, error) {
+	var sb strings.Builder
+	sb.WriteString("^")
+
+	for rest := pattern; rest != ""; {
+		start := strings.IndexByte(rest, '{')
+		if start < 0 {
+			sb.WriteString(regexp.QuoteMeta(rest))
+			break
+		}
+		end := strings.IndexByte(rest[start:], '}')
+		if end < 0 {
+			return nil, errors.Errorf("unclosed wildcard in the pattern: %s", pattern)
+		}
+		end += start
+
+		sb.WriteString(regexp.QuoteMeta(rest[:start]))
+		if strings.HasSuffix(rest[start+1:end], "...") {
+			sb.WriteString(".*")
+		} else {
+			sb.WriteString("[^/]+")
+		}
+		rest = rest[end+1:]
+	}
+
+	sb.WriteString("$")
+	return regexp.Compile(sb.String())
+}
+
+func withRoute(ctx context.Context, route string) context.Context {
+	return context.WithValue(ctx, routeKey{}, route)
+}
+
+func routeFromContext(ctx context.Context) string {
+	route, _ := ctx.Value(routeKey{}).(string)
+	return route
+}
+
+// spanName follows the HTTP semantic conventions: "{method} {route}" or just "{method}" when the route is unknown
+func spanName(r *http.Request, route string) string {
+	method := requestMethod(r.Method)
+	if method == httpconv.RequestMethodOther {
+		method = "HTTP"
+	}
+	if route == "" {
+		return string(method)
+	}
+	return string(method) + " " + route
+}
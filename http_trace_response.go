@@ -0,0 +1,71 @@
+package otel
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TraceResponse returns the server span context to the clients in the response headers
+type TraceResponse struct {
+	// TraceResponse adds the W3C Trace Context Level 2 traceresponse header
+	TraceResponse bool `mapstructure:"traceresponse"`
+	// ServerTiming adds the Server-Timing: traceparent;desc="..." header
+	ServerTiming bool `mapstructure:"server_timing"`
+	// TraceIDHeader is the name of the custom header with the trace ID, e.g. X-Trace-Id
+	TraceIDHeader string `mapstructure:"trace_id_header"`
+}
+
+// traceResponseHook adds the configured headers with the span context to the response, and exposes them to CORS requests
+func traceResponseHook(cfg *TraceResponse, span trace.Span) headersHook {
+	return func(header http.Header, _ int) {
+		sc := span.SpanContext()
+		if !sc.IsValid() {
+			return
+		}
+
+		// https://www.w3.org/TR/trace-context-2/#traceresponse-header
+		value := fmt.Sprintf("00-%s-%s-%s", sc.TraceID(), sc.SpanID(), sc.TraceFlags())
+
+		var exposed []string
+		if cfg.TraceResponse {
+			header.Set("Traceresponse", value)
+			exposed = append(exposed, "traceresponse")
+		}
+		if cfg.ServerTiming {
+			// the worker may report its own metrics
+			header.Add("Server-Timing", fmt.Sprintf("traceparent;desc=%q", value))
+			exposed = append(exposed, "Server-Timing")
+		}
+		if cfg.TraceIDHeader != "" {
+			header.Set(cfg.TraceIDHeader, sc.TraceID().String())
+			exposed = append(exposed, cfg.TraceIDHeader)
+		}
+
+		exposeHeaders(header, exposed)
+	}
+}
+
+// exposeHeaders appends the names missing from the Access-Control-Expose-Headers header
+func exposeHeaders(header http.Header, names []string) {
+	var listed []string
+	for _, value := range header.Values("Access-Control-Expose-Headers") {
+		for name := range strings.SplitSeq(value, ",") {
+			name = strings.TrimSpace(name)
+			// all the headers are already exposed
+			if name == "*" {
+				return
+			}
+			listed = append(listed, strings.ToLower(name))
+		}
+	}
+
+	for _, name := range names {
+		if !slices.Contains(listed, strings.ToLower(name)) {
+			header.Add("Access-Control-Expose-Headers", name)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package otel
+
+import (
+	"context"
+	"crypto/subtle"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/roadrunner-server/errors"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Trust limits the sources allowed to continue their trace. The incoming context of the untrusted requests is
+// not used as the parent: the server span starts a new trace and links the incoming span context, the baggage is dropped.
+// A request is trusted when it comes from one of the CIDRs or carries the Header (with the Secret value, if set).
+type Trust struct {
+	// CIDRs of the trusted peers, e.g. 10.0.0.0/8. A single IP address is accepted as well
+	CIDRs []string `mapstructure:"cidrs"`
+	// Header marks the trusted requests, it is removed before the request is passed to the worker
+	Header string `mapstructure:"header"`
+	// Secret is the expected Header value
+	Secret string `mapstructure:"secret"`
+}
+
+type untrustedKey struct{}
+
+type trustChecker struct {
+	prefixes []netip.Prefix
+	header   string
+	secret   []byte
+}
+
+func newTrustChecker(cfg *Trust) (*trustChecker, error) {
+	const op = errors.Op("otel_new_trust_checker")
+
+	prefixes, err := parsePrefixes(cfg.CIDRs)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	tc := &trustChecker{
+		prefixes: prefixes,
+		header:   http.CanonicalHeaderKey(cfg.Header),
+		secret:   []byte(cfg.Secret),
+	}
+
+	if tc.header == "" && len(tc.secret) > 0 {
+		return nil, errors.E(op, errors.Str("trust secret is set without the header"))
+	}
+
+	return tc, nil
+}
+
+func (tc *trustChecker) trusted(r *http.Request) bool {
+	if tc.header != "" {
+		if values, ok := r.Header[tc.header]; ok {
+			r.Header.Del(tc.header)
+			if len(tc.secret) == 0 || (len(values) == 1 && subtle.ConstantTimeCompare([]byte(values[0]), tc.secret) == 1) {
+				return true
+			}
+		}
+	}
+
+	addr, ok := parseAddr(r.RemoteAddr)
+	return ok && containsAddr(tc.prefixes, addr)
+}
+
+// parsePrefixes parses the CIDRs, a single IP address is accepted as well
+func parsePrefixes(cidrs []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			addr, errAddr := netip.ParseAddr(cidr)
+			if errAddr != nil {
+				return nil, errors.Errorf("invalid CIDR %q: %v", cidr, err)
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// parseAddr parses the IP address with an optional port, e.g. the request RemoteAddr
+func parseAddr(hostport string) (netip.Addr, bool) {
+	host, _, err := net.SplitHostPort(hostport)
+	if err != nil {
+		host = strings.Trim(hostport, "[]")
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap(), true
+}
+
+func containsAddr(prefixes []netip.Prefix, addr netip.Addr) bool {
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// untrust removes the incoming trace context and baggage headers and stores the incoming span context
+// to be linked from the server span
+func untrust(ctx context.Context, r *http.Request, prop propagation.TextMapPropagator) context.Context {
+	carrier := propagation.HeaderCarrier(r.Header)
+	sc := trace.SpanContextFromContext(prop.Extract(context.Background(), carrier))
+	for _, field := range prop.Fields() {
+		r.Header.Del(field)
+	}
+
+	if !sc.IsValid() {
+		return ctx
+	}
+	return context.WithValue(ctx, untrustedKey{}, sc)
+}
+
+func untrustedFromContext(ctx context.Context) (trace.SpanContext, bool) {
+	sc, ok := ctx.Value(untrustedKey{}).(trace.SpanContext)
+	return sc, ok
+}
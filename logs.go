@@ -0,0 +1,115 @@
+package otel
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/roadrunner-server/errors"
+	"go.opentelemetry.io/otel/exporters/otlp/otlplog/otlploggrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlplog/otlploghttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdoutlog"
+	sdklog "go.opentelemetry.io/otel/sdk/log"
+	"go.opentelemetry.io/otel/sdk/resource"
+)
+
+// Logs configures the logs pipeline
+type Logs struct {
+	SignalExporter `mapstructure:",squash"`
+}
+
+func (l *Logs) initDefault(c *Config, log *slog.Logger) {
+	l.SignalExporter.initDefault(c, "OTEL_EXPORTER_OTLP_LOGS_PROTOCOL", log)
+	if l.Client == jsonClient {
+		log.Warn("http/json client is supported only for traces, http is used instead")
+		l.Client = httpClient
+	}
+	if l.Exporter == fileExp {
+		log.Warn("file exporter is supported only for traces, otlp is used for the logs")
+		l.Exporter = otlp
+	}
+}
+
+func newLoggerProvider(cfg *Logs, res *resource.Resource) (*sdklog.LoggerProvider, error) {
+	const op = errors.Op("otel_new_logger_provider")
+
+	var exporter sdklog.Exporter
+	var err error
+
+	switch cfg.Exporter { //nolint:exhaustive
+	case stdout:
+		exporter, err = stdoutlog.New(stdoutlog.WithPrettyPrint(), stdoutlog.WithWriter(os.Stdout))
+	case stderr:
+		exporter, err = stdoutlog.New(stdoutlog.WithPrettyPrint(), stdoutlog.WithWriter(os.Stderr))
+	case otlp:
+		// 1 min timeout
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+
+		switch cfg.Client { //nolint:exhaustive
+		case httpClient:
+			exporter, err = otlploghttp.New(ctx, logsHTTPOptions(&cfg.SignalExporter)...)
+		case grpcClient:
+			exporter, err = otlploggrpc.New(ctx, logsGRPCOptions(&cfg.SignalExporter)...)
+		default:
+			return nil, errors.E(op, errors.Errorf("unknown logs client: %s", cfg.Client))
+		}
+	default:
+		return nil, errors.E(op, errors.Errorf("unsupported logs exporter: %s", cfg.Exporter))
+	}
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	return sdklog.NewLoggerProvider(
+		sdklog.WithProcessor(sdklog.NewBatchProcessor(exporter)),
+		sdklog.WithResource(res),
+	), nil
+}
+
+func logsGRPCOptions(cfg *SignalExporter) []otlploggrpc.Option {
+	var options []otlploggrpc.Option
+	if *cfg.Insecure {
+		options = append(options, otlploggrpc.WithInsecure())
+	}
+	if *cfg.Compress {
+		options = append(options, otlploggrpc.WithCompressor("gzip"))
+	}
+
+	// if unset, OTEL will use the default one automatically
+	if cfg.Endpoint != "" {
+		options = append(options, otlploggrpc.WithEndpoint(cfg.Endpoint))
+	}
+
+	if len(cfg.Headers) > 0 {
+		options = append(options, otlploggrpc.WithHeaders(cfg.Headers))
+	}
+
+	return options
+}
+
+func logsHTTPOptions(cfg *SignalExporter) []otlploghttp.Option {
+	var options []otlploghttp.Option
+	if *cfg.Insecure {
+		options = append(options, otlploghttp.WithInsecure())
+	}
+	if *cfg.Compress {
+		options = append(options, otlploghttp.WithCompression(otlploghttp.GzipCompression))
+	}
+
+	if cfg.CustomURL != "" {
+		options = append(options, otlploghttp.WithURLPath(cfg.CustomURL))
+	}
+
+	// if unset, OTEL will use the default one automatically
+	if cfg.Endpoint != "" {
+		options = append(options, otlploghttp.WithEndpoint(cfg.Endpoint))
+	}
+
+	if len(cfg.Headers) > 0 {
+		options = append(options, otlploghttp.WithHeaders(cfg.Headers))
+	}
+
+	return options
+}
@@ -0,0 +1,153 @@
+package otel
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/roadrunner-server/errors"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdoutmetric"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+)
+
+// Metrics configures the metrics pipeline
+type Metrics struct {
+	SignalExporter `mapstructure:",squash"`
+	// Interval between the exports. Defaults to 60s
+	Interval time.Duration `mapstructure:"interval"`
+	// HTTPDurationBuckets overrides the http.server.request.duration histogram boundaries (seconds)
+	HTTPDurationBuckets []float64 `mapstructure:"http_duration_buckets"`
+	// HTTPBodySizeBuckets overrides the http.server.{request,response}.body.size histogram boundaries (bytes)
+	HTTPBodySizeBuckets []float64 `mapstructure:"http_body_size_buckets"`
+}
+
+func (m *Metrics) initDefault(c *Config, log *slog.Logger) {
+	m.SignalExporter.initDefault(c, "OTEL_EXPORTER_OTLP_METRICS_PROTOCOL", log)
+	if m.Client == jsonClient {
+		log.Warn("http/json client is supported only for traces, http is used instead")
+		m.Client = httpClient
+	}
+	if m.Exporter == fileExp {
+		log.Warn("file exporter is supported only for traces, otlp is used for the metrics")
+		m.Exporter = otlp
+	}
+
+	if m.Interval <= 0 {
+		m.Interval = time.Minute
+	}
+
+	// the SDK expects the boundaries in increasing order
+	slices.Sort(m.HTTPDurationBuckets)
+	slices.Sort(m.HTTPBodySizeBuckets)
+}
+
+func newMeterProvider(cfg *Metrics, res *resource.Resource) (*sdkmetric.MeterProvider, error) {
+	const op = errors.Op("otel_new_meter_provider")
+
+	var exporter sdkmetric.Exporter
+	var err error
+
+	switch cfg.Exporter { //nolint:exhaustive
+	case stdout:
+		exporter, err = stdoutmetric.New(stdoutmetric.WithPrettyPrint(), stdoutmetric.WithWriter(os.Stdout))
+	case stderr:
+		exporter, err = stdoutmetric.New(stdoutmetric.WithPrettyPrint(), stdoutmetric.WithWriter(os.Stderr))
+	case otlp:
+		// 1 min timeout
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+
+		switch cfg.Client { //nolint:exhaustive
+		case httpClient:
+			exporter, err = otlpmetrichttp.New(ctx, metricsHTTPOptions(&cfg.SignalExporter)...)
+		case grpcClient:
+			exporter, err = otlpmetricgrpc.New(ctx, metricsGRPCOptions(&cfg.SignalExporter)...)
+		default:
+			return nil, errors.E(op, errors.Errorf("unknown metrics client: %s", cfg.Client))
+		}
+	default:
+		return nil, errors.E(op, errors.Errorf("unsupported metrics exporter: %s", cfg.Exporter))
+	}
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	return sdkmetric.NewMeterProvider(
+		sdkmetric.WithReader(sdkmetric.NewPeriodicReader(exporter, sdkmetric.WithInterval(cfg.Interval))),
+		sdkmetric.WithResource(res),
+		sdkmetric.WithView(httpViews(cfg)...),
+	), nil
+}
+
+// httpViews overrides the bucket boundaries of the HTTP server histograms
+func httpViews(cfg *Metrics) []sdkmetric.View {
+	var views []sdkmetric.View
+	if len(cfg.HTTPDurationBuckets) > 0 {
+		views = append(views, bucketsView("http.server.request.duration", cfg.HTTPDurationBuckets))
+	}
+	if len(cfg.HTTPBodySizeBuckets) > 0 {
+		views = append(views,
+			bucketsView("http.server.request.body.size", cfg.HTTPBodySizeBuckets),
+			bucketsView("http.server.response.body.size", cfg.HTTPBodySizeBuckets),
+		)
+	}
+	return views
+}
+
+func bucketsView(name string, boundaries []float64) sdkmetric.View {
+	return sdkmetric.NewView(
+		sdkmetric.Instrument{Name: name},
+		sdkmetric.Stream{Aggregation: sdkmetric.AggregationExplicitBucketHistogram{Boundaries: boundaries}},
+	)
+}
+
+func metricsGRPCOptions(cfg *SignalExporter) []otlpmetricgrpc.Option {
+	var options []otlpmetricgrpc.Option
+	if *cfg.Insecure {
+		options = append(options, otlpmetricgrpc.WithInsecure())
+	}
+	if *cfg.Compress {
+		options = append(options, otlpmetricgrpc.WithCompressor("gzip"))
+	}
+
+	// if unset, OTEL will use the default one automatically
+	if cfg.Endpoint != "" {
+		options = append(options, otlpmetricgrpc.WithEndpoint(cfg.Endpoint))
+	}
+
+	if len(cfg.Headers) > 0 {
+		options = append(options, otlpmetricgrpc.WithHeaders(cfg.Headers))
+	}
+
+	return options
+}
+
+func metricsHTTPOptions(cfg *SignalExporter) []otlpmetrichttp.Option {
+	var options []otlpmetrichttp.Option
+	if *cfg.Insecure {
+		options = append(options, otlpmetrichttp.WithInsecure())
+	}
+	if *cfg.Compress {
+		options = append(options, otlpmetrichttp.WithCompression(otlpmetrichttp.GzipCompression))
+	}
+
+	if cfg.CustomURL != "" {
+		options = append(options, otlpmetrichttp.WithURLPath(cfg.CustomURL))
+	}
+
+	// if unset, OTEL will use the default one automatically
+	if cfg.Endpoint != "" {
+		options = append(options, otlpmetrichttp.WithEndpoint(cfg.Endpoint))
+	}
+
+	if len(cfg.Headers) > 0 {
+		options = append(options, otlpmetrichttp.WithHeaders(cfg.Headers))
+	}
+
+	return options
+}
@@ -0,0 +1,187 @@
+package otel
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/roadrunner-server/errors"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+const (
+	defaultJSONEndpoint = "localhost:4318"
+	defaultJSONPath     = "/v1/traces"
+	jsonExportTimeout   = 10 * time.Second
+)
+
+// otlpJSONClient is the otlptrace.Client sending the span batches in the OTLP/HTTP JSON encoding
+// https://opentelemetry.io/docs/specs/otlp/#json-protobuf-encoding
+type otlpJSONClient struct {
+	url      string
+	compress bool
+	headers  map[string]string
+	client   *http.Client
+}
+
+func newOTLPJSONClient(cfg *SignalExporter) *otlpJSONClient {
+	return &otlpJSONClient{
+		url:      jsonURL(cfg),
+		compress: *cfg.Compress,
+		headers:  cfg.Headers,
+		client:   &http.Client{Timeout: jsonExportTimeout},
+	}
+}
+
+// jsonURL builds the traces URL from the config, the OTLP endpoint env variables or the defaults
+func jsonURL(cfg *SignalExporter) string {
+	endpoint := cfg.Endpoint
+	if endpoint == "" {
+		// https://opentelemetry.io/docs/languages/sdk-configuration/otlp-exporter/#endpoint-configuration
+		if env := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT"); env != "" {
+			return env
+		}
+		if env := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"); env != "" {
+			return strings.TrimSuffix(env, "/") + defaultJSONPath
+		}
+		endpoint = defaultJSONEndpoint
+	}
+
+	scheme := "https://"
+	if *cfg.Insecure {
+		scheme = "http://"
+	}
+
+	path := defaultJSONPath
+	if cfg.CustomURL != "" {
+		path = cfg.CustomURL
+	}
+
+	return scheme + endpoint + path
+}
+
+func (c *otlpJSONClient) Start(context.Context) error {
+	return nil
+}
+
+func (c *otlpJSONClient) Stop(context.Context) error {
+	c.client.CloseIdleConnections()
+	return nil
+}
+
+func (c *otlpJSONClient) UploadTraces(ctx context.Context, protoSpans []*tracepb.ResourceSpans) error {
+	const op = errors.Op("otel_json_client_upload")
+
+	body, err := marshalTracesJSON(protoSpans)
+	if err != nil {
+		return errors.E(op, err)
+	}
+
+	if c.compress {
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		if _, err = gz.Write(body); err != nil {
+			return errors.E(op, err)
+		}
+		if err = gz.Close(); err != nil {
+			return errors.E(op, err)
+		}
+		body = buf.Bytes()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(body))
+	if err != nil {
+		return errors.E(op, err)
+	}
+	for k, v := range c.headers {
+		req.Header.Set(k, v)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.compress {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return errors.E(op, err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return errors.E(op, errors.Errorf("unexpected response status %s: %s", resp.Status, bytes.TrimSpace(msg)))
+	}
+
+	// drain the body to reuse the connection
+	_, _ = io.Copy(io.Discard, resp.Body)
+	return nil
+}
+
+// marshalTracesJSON encodes the spans as the ExportTraceServiceRequest in the OTLP JSON mapping:
+// enums as integers and the trace and span IDs as hex strings (protojson uses base64 for bytes)
+func marshalTracesJSON(protoSpans []*tracepb.ResourceSpans) ([]byte, error) {
+	data, err := protojson.MarshalOptions{UseEnumNumbers: true}.Marshal(&coltracepb.ExportTraceServiceRequest{
+		ResourceSpans: protoSpans,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	// keep the numbers as is
+	dec.UseNumber()
+
+	var doc any
+	if err = dec.Decode(&doc); err != nil {
+		return nil, err
+	}
+	if err = hexIDs(doc); err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(doc)
+}
+
+// hexIDs re-encodes the base64 traceId, spanId and parentSpanId values as hex
+func hexIDs(v any) error {
+	switch val := v.(type) {
+	case map[string]any:
+		for k, field := range val {
+			switch k {
+			case "traceId", "spanId", "parentSpanId":
+				s, ok := field.(string)
+				if !ok {
+					continue
+				}
+				id, err := base64.StdEncoding.DecodeString(s)
+				if err != nil {
+					return err
+				}
+				val[k] = hex.EncodeToString(id)
+			default:
+				if err := hexIDs(field); err != nil {
+					return err
+				}
+			}
+		}
+	case []any:
+		for _, item := range val {
+			if err := hexIDs(item); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -4,18 +4,19 @@ import (
 	"context"
 	"log/slog"
 	"net/http"
-	"os"
 	"runtime"
+	"slices"
 	"time"
 
 	"github.com/roadrunner-server/errors"
-	jprop "go.opentelemetry.io/contrib/propagators/jaeger"
+	"go.opentelemetry.io/contrib/bridges/otelslog"
+	"go.opentelemetry.io/contrib/propagators/autoprop"
 	"go.opentelemetry.io/otel"
-	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
-	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
-	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
-	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/log/global"
+	"go.opentelemetry.io/otel/metric"
 	"go.opentelemetry.io/otel/propagation"
+	sdklog "go.opentelemetry.io/otel/sdk/log"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
 	"go.opentelemetry.io/otel/sdk/resource"
 	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 	semconv "go.opentelemetry.io/otel/semconv/v1.43.0"
@@ -27,6 +28,8 @@ import (
 
 const (
 	pluginName = "otel"
+	// shutdownTimeout bounds the shutdown of the providers started by a failed Init
+	shutdownTimeout = 5 * time.Second
 )
 
 type Logger interface {
@@ -46,19 +49,24 @@ type Plugin struct {
 	cfg                 *Config
 	log                 *slog.Logger
 	tracer              *sdktrace.TracerProvider
+	meter               *sdkmetric.MeterProvider
+	logger              *sdklog.LoggerProvider
+	tailSampler         *tailSampler
+	queues              []*diskQueue
+	remoteSampler       *remoteSampler
 	propagators         propagation.TextMapPropagator
 	httpMiddleware      httpMiddleware
 	temporalInterceptor interceptor.WorkerInterceptor
 }
 
-func (p *Plugin) Init(cfg Configurer, log Logger) error { //nolint:gocyclo
+func (p *Plugin) Init(cfg Configurer, log Logger) (err error) { //nolint:gocyclo
 	const op = errors.Op("otel_plugin_init")
 
 	if !cfg.Has(pluginName) {
 		return errors.E(errors.Disabled)
 	}
 
-	err := cfg.UnmarshalKey(pluginName, &p.cfg)
+	err = cfg.UnmarshalKey(pluginName, &p.cfg)
 	if err != nil {
 		return errors.E(op, err)
 	}
@@ -68,62 +76,145 @@ func (p *Plugin) Init(cfg Configurer, log Logger) error { //nolint:gocyclo
 
 	// init default configuration
 	p.cfg.InitDefault(p.log)
+	if err = checkQueueDirs(p.cfg); err != nil {
+		return errors.E(op, err)
+	}
 
-	var exporter sdktrace.SpanExporter
-	var client otlptrace.Client
+	res, err := newResource(p.cfg.Resource, cfg.RRVersion())
+	if err != nil {
+		return errors.E(op, err)
+	}
 
-	switch p.cfg.Exporter {
-	case stdout:
-		exporter, err = stdouttrace.New(stdouttrace.WithPrettyPrint(), stdouttrace.WithWriter(os.Stdout))
-		if err != nil {
-			return err
+	// Stop is not called after a failed Init, so everything started so far is shut down here
+	var cleanup []func(context.Context) error
+	defer func() {
+		if err == nil {
+			return
 		}
-	case jaegerExp:
-		return errors.Errorf("jaeger exporter is deprecated, use OTLP instead: https://github.com/roadrunner-server/roadrunner/issues/1699")
-	case stderr:
-		exporter, err = stdouttrace.New(stdouttrace.WithPrettyPrint(), stdouttrace.WithWriter(os.Stderr))
-		if err != nil {
-			return err
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		for _, fn := range slices.Backward(cleanup) {
+			_ = fn(ctx)
 		}
-	case zipkinExp:
-		return errors.Errorf("zipkin exporter is deprecated, use OTLP instead")
-	case otlp:
-		switch p.cfg.Client {
-		case httpClient:
-			client = otlptracehttp.NewClient(httpOptions(p.cfg)...)
-		case grpcClient:
-			client = otlptracegrpc.NewClient(grpcOptions(p.cfg)...)
-		default:
-			return errors.Errorf("unknown client: %s", p.cfg.Client)
+	}()
+
+	if p.cfg.Metrics != nil {
+		p.meter, err = newMeterProvider(p.cfg.Metrics, res)
+		if err != nil {
+			return errors.E(op, err)
 		}
+		cleanup = append(cleanup, p.meter.Shutdown)
+	}
 
-		// 1 min timeout
-		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
-		defer cancel()
-		exporter, err = otlptrace.New(ctx, client)
+	if p.cfg.Logs != nil {
+		p.logger, err = newLoggerProvider(p.cfg.Logs, res)
 		if err != nil {
 			return errors.E(op, err)
 		}
-	default:
-		return errors.Errorf("unknown exporter: %s", p.cfg.Exporter)
+		cleanup = append(cleanup, p.logger.Shutdown)
 	}
 
-	res, err := newResource(p.cfg.Resource, cfg.RRVersion())
+	if p.cfg.Sampler.Type == remote || p.cfg.Sampler.Type == parentBasedRemote {
+		// the sampler ratio is the default probability used while the remote endpoint is unavailable
+		p.remoteSampler = newRemoteSampler(p.cfg.Sampler.Remote, sdktrace.TraceIDRatioBased(*p.cfg.Sampler.Ratio), p.cfg.Resource.ServiceNameKey, p.log)
+		cleanup = append(cleanup, func(context.Context) error {
+			p.remoteSampler.Close()
+			return nil
+		})
+	}
+
+	sampler, err := newSampler(p.cfg.Sampler, p.remoteSampler)
 	if err != nil {
 		return errors.E(op, err)
 	}
-	p.tracer = sdktrace.NewTracerProvider(
-		sdktrace.WithBatcher(exporter),
+
+	// each exporter has its own batch processor, so a slow backend does not stall the others
+	processors := make(fanoutProcessor, 0, len(p.cfg.Exporters))
+	for _, e := range p.cfg.Exporters {
+		exporter, queue, errE := newSpanExporter(e, p.log)
+		if errE != nil {
+			return errors.E(op, errE)
+		}
+		if queue != nil {
+			p.queues = append(p.queues, queue)
+		}
+		processor := sdktrace.NewBatchSpanProcessor(exporter, batchOptions(e.Batch)...)
+		cleanup = append(cleanup, processor.Shutdown)
+		processors = append(processors, processor)
+	}
+
+	if p.cfg.Routing != nil {
+		routes := make([]sdktrace.SpanProcessor, 0, len(p.cfg.Routing.Routes))
+		for _, route := range p.cfg.Routing.Routes {
+			exporter, queue, errE := newSpanExporter(&route.SpanExporter, p.log)
+			if errE != nil {
+				return errors.E(op, errE)
+			}
+			if queue != nil {
+				p.queues = append(p.queues, queue)
+			}
+			processor := sdktrace.NewBatchSpanProcessor(exporter, batchOptions(route.Batch)...)
+			cleanup = append(cleanup, processor.Shutdown)
+			routes = append(routes, processor)
+		}
+
+		// the exporters receive the spans not matching any route
+		router, errR := newRoutingProcessor(p.cfg.Routing, routes, processors)
+		if errR != nil {
+			return errors.E(op, errR)
+		}
+		processors = fanoutProcessor{router}
+	}
+
+	opts := []sdktrace.TracerProviderOption{
 		sdktrace.WithResource(res),
-	)
+		sdktrace.WithSampler(sampler),
+	}
+	if p.cfg.TailSampling != nil {
+		p.tailSampler = newTailSampler(p.cfg.TailSampling, processors)
+		opts = append(opts, sdktrace.WithSpanProcessor(p.tailSampler))
+	} else {
+		for _, processor := range processors {
+			opts = append(opts, sdktrace.WithSpanProcessor(processor))
+		}
+	}
 
-	p.propagators = propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}, jprop.Jaeger{})
-	p.httpMiddleware = httpWrapper(p.propagators, p.tracer, p.cfg.ServiceName)
+	p.tracer = sdktrace.NewTracerProvider(opts...)
+	// also stops the tail sampler
+	cleanup = append(cleanup, p.tracer.Shutdown)
+
+	p.propagators, err = autoprop.TextMapPropagator(p.cfg.Propagators...)
+	if err != nil {
+		return errors.E(op, err)
+	}
+
+	// without the metrics section, the HTTP metrics go to the global meter provider (no-op unless set by someone else)
+	var mp metric.MeterProvider = otel.GetMeterProvider()
+	if p.meter != nil {
+		mp = p.meter
+	}
+	// the exporting processors only, flushing the provider would make the tail sampler decide the incomplete traces
+	var flush func(context.Context) error
+	if p.tailSampler == nil {
+		flush = processors.ForceFlush
+	}
+	p.httpMiddleware, err = httpWrapper(p.cfg.HTTP, p.propagators, p.tracer, mp, flush, p.cfg.ServiceName)
+	if err != nil {
+		return errors.E(op, err)
+	}
 	p.temporalInterceptor, err = newTemporalInterceptor(p.propagators, p.tracer)
 	if err != nil {
 		return errors.E(op, err)
 	}
+	// the global providers are registered only once nothing can fail anymore
+	if p.meter != nil {
+		otel.SetMeterProvider(p.meter)
+	}
+	if p.logger != nil {
+		global.SetLoggerProvider(p.logger)
+	}
 	otel.SetTracerProvider(p.tracer)
+	otel.SetTextMapPropagator(p.propagators)
 
 	return nil
 }
@@ -141,18 +232,92 @@ func (p *Plugin) Serve() chan error {
 }
 
 func (p *Plugin) Stop(ctx context.Context) error {
+	if p.remoteSampler != nil {
+		p.remoteSampler.Close()
+	}
+	if p.meter != nil {
+		// https://github.com/open-telemetry/opentelemetry-specification/blob/main/specification/metrics/sdk.md#forceflush
+		if err := p.meter.ForceFlush(ctx); err != nil {
+			p.log.Error("failed to flush metrics", "error", err)
+		}
+		// https://github.com/open-telemetry/opentelemetry-specification/blob/main/specification/metrics/sdk.md#shutdown
+		if err := p.meter.Shutdown(ctx); err != nil {
+			p.log.Error("failed to shutdown the meter provider", "error", err)
+		}
+	}
+	if p.logger != nil {
+		// https://github.com/open-telemetry/opentelemetry-specification/blob/main/specification/logs/sdk.md#forceflush
+		if err := p.logger.ForceFlush(ctx); err != nil {
+			p.log.Error("failed to flush logs", "error", err)
+		}
+		// https://github.com/open-telemetry/opentelemetry-specification/blob/main/specification/logs/sdk.md#shutdown
+		if err := p.logger.Shutdown(ctx); err != nil {
+			p.log.Error("failed to shutdown the logger provider", "error", err)
+		}
+	}
 	// https://github.com/open-telemetry/opentelemetry-specification/blob/main/specification/trace/sdk.md#forceflush
 	if err := p.tracer.ForceFlush(ctx); err != nil {
 		return err
 	}
+	if p.tailSampler != nil && p.tailSampler.Dropped() > 0 {
+		p.log.Warn("tail sampling dropped traces due to the max_traces limit", "dropped", p.tailSampler.Dropped())
+	}
 	// https://github.com/open-telemetry/opentelemetry-specification/blob/main/specification/trace/sdk.md#shutdown
-	return p.tracer.Shutdown(ctx)
+	err := p.tracer.Shutdown(ctx)
+	for _, q := range p.queues {
+		if q.Dropped() > 0 {
+			p.log.Warn("the export queue dropped span batches due to the max_size limit", "dir", q.cfg.Dir, "dropped", q.Dropped())
+		}
+	}
+	return err
 }
 
 func (p *Plugin) Tracer() *sdktrace.TracerProvider {
 	return p.tracer
 }
 
+// MeterProvider returns the meter provider, nil when the metrics are not configured
+func (p *Plugin) MeterProvider() *sdkmetric.MeterProvider {
+	return p.meter
+}
+
+// LoggerProvider returns the logger provider, nil when the logs are not configured
+func (p *Plugin) LoggerProvider() *sdklog.LoggerProvider {
+	return p.logger
+}
+
+// LogHandler returns a slog handler emitting the records to the logs pipeline under the given
+// instrumentation scope name. The trace_id and span_id are taken from the context passed to the
+// slog logger (e.g. InfoContext). Returns nil when the logs are not configured.
+func (p *Plugin) LogHandler(name string) slog.Handler {
+	if p.logger == nil {
+		return nil
+	}
+	return otelslog.NewHandler(name, otelslog.WithLoggerProvider(p.logger))
+}
+
+// TailSamplingDropped returns the number of traces the tail sampler dropped because its
+// max_traces limit was reached. Always 0 when the tail sampling is disabled.
+func (p *Plugin) TailSamplingDropped() uint64 {
+	if p.tailSampler == nil {
+		return 0
+	}
+	return p.tailSampler.Dropped()
+}
+
+// QueueBacklog returns the number and the total size in bytes of the span batches waiting
+// in the on-disk export queues. Always 0 when no queue is configured.
+func (p *Plugin) QueueBacklog() (int, int64) {
+	var batches int
+	var size int64
+	for _, q := range p.queues {
+		b, s := q.Backlog()
+		batches += b
+		size += s
+	}
+	return batches, size
+}
+
 func (p *Plugin) Name() string {
 	return pluginName
 }
@@ -174,49 +339,3 @@ func newResource(res *Resource, rrVersion string) (*resource.Resource, error) {
 		resource.WithTelemetrySDK(),
 	)
 }
-
-func grpcOptions(cfg *Config) []otlptracegrpc.Option {
-	var options []otlptracegrpc.Option
-	if cfg.Insecure {
-		options = append(options, otlptracegrpc.WithInsecure())
-	}
-	if cfg.Compress {
-		options = append(options, otlptracegrpc.WithCompressor("gzip"))
-	}
-
-	// if unset, OTEL will use the default one automatically
-	if cfg.Endpoint != "" {
-		options = append(options, otlptracegrpc.WithEndpoint(cfg.Endpoint))
-	}
-
-	if len(cfg.Headers) > 0 {
-		options = append(options, otlptracegrpc.WithHeaders(cfg.Headers))
-	}
-
-	return options
-}
-
-func httpOptions(cfg *Config) []otlptracehttp.Option {
-	var options []otlptracehttp.Option
-	if cfg.Insecure {
-		options = append(options, otlptracehttp.WithInsecure())
-	}
-	if cfg.Compress {
-		options = append(options, otlptracehttp.WithCompression(otlptracehttp.GzipCompression))
-	}
-
-	if cfg.CustomURL != "" {
-		options = append(options, otlptracehttp.WithURLPath(cfg.CustomURL))
-	}
-
-	// if unset, OTEL will use the default one automatically
-	if cfg.Endpoint != "" {
-		options = append(options, otlptracehttp.WithEndpoint(cfg.Endpoint))
-	}
-
-	if len(cfg.Headers) > 0 {
-		options = append(options, otlptracehttp.WithHeaders(cfg.Headers))
-	}
-
-	return options
-}
@@ -0,0 +1,127 @@
+package otel
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-logr/logr"
+	"go.opentelemetry.io/contrib/samplers/jaegerremote"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+const (
+	remoteFetchTimeout = 10 * time.Second
+)
+
+// RemoteSampler configures the Jaeger-compatible remote sampling strategy endpoint
+type RemoteSampler struct {
+	// URL of the sampling endpoint, the service name is passed in the query. Defaults to http://localhost:5778/sampling
+	URL string `mapstructure:"url"`
+	// PollingInterval between the strategy fetches. Defaults to 1m
+	PollingInterval time.Duration `mapstructure:"polling_interval"`
+	// MaxOperations is the maximum number of the per-operation samplers. Defaults to 256
+	MaxOperations int `mapstructure:"max_operations"`
+}
+
+// remoteSampler uses the strategies served by the remote endpoint while it's reachable
+// and the fallback sampler otherwise.
+type remoteSampler struct {
+	remote   *jaegerremote.Sampler
+	fetcher  *remoteFetcher
+	fallback sdktrace.Sampler
+}
+
+func newRemoteSampler(cfg *RemoteSampler, fallback sdktrace.Sampler, serviceName string, log *slog.Logger) *remoteSampler {
+	fetcher := &remoteFetcher{
+		url:    cfg.URL,
+		log:    log,
+		client: &http.Client{Timeout: remoteFetchTimeout},
+	}
+
+	return &remoteSampler{
+		fetcher:  fetcher,
+		fallback: fallback,
+		remote: jaegerremote.New(serviceName,
+			jaegerremote.WithSamplingServerURL(cfg.URL),
+			jaegerremote.WithSamplingStrategyFetcher(fetcher),
+			jaegerremote.WithSamplingRefreshInterval(cfg.PollingInterval),
+			jaegerremote.WithMaxOperations(cfg.MaxOperations),
+			jaegerremote.WithInitialSampler(fallback),
+			jaegerremote.WithLogger(logr.FromSlogHandler(log.Handler())),
+		),
+	}
+}
+
+func (s *remoteSampler) ShouldSample(p sdktrace.SamplingParameters) sdktrace.SamplingResult {
+	if !s.fetcher.available.Load() {
+		return s.fallback.ShouldSample(p)
+	}
+	return s.remote.ShouldSample(p)
+}
+
+func (s *remoteSampler) Description() string {
+	return fmt.Sprintf("Remote{url:%s,fallback:%s}", s.fetcher.url, s.fallback.Description())
+}
+
+// Close stops polling the remote endpoint
+func (s *remoteSampler) Close() {
+	s.remote.Close()
+}
+
+// remoteFetcher implements jaegerremote.SamplingStrategyFetcher and tracks the endpoint availability
+type remoteFetcher struct {
+	url       string
+	log       *slog.Logger
+	client    *http.Client
+	available atomic.Bool
+}
+
+func (f *remoteFetcher) Fetch(serviceName string) ([]byte, error) {
+	body, err := f.fetch(serviceName)
+	if err != nil {
+		if f.available.Swap(false) {
+			f.log.Warn("remote sampling endpoint is unavailable, using the default sampler", "url", f.url, "error", err)
+		}
+		return nil, err
+	}
+
+	if !f.available.Swap(true) {
+		f.log.Debug("remote sampling strategy fetched", "url", f.url)
+	}
+	return body, nil
+}
+
+func (f *remoteFetcher) fetch(serviceName string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), remoteFetchTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, f.url+"?"+url.Values{"service": {serviceName}}.Encode(), http.NoBody)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	return body, nil
+}
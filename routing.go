@@ -0,0 +1,149 @@
+package otel
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+
+	"github.com/roadrunner-server/errors"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Routing dispatches the spans to the route exporters by the value of a span or resource attribute, e.g. tenant.id.
+// The child spans follow the route of their parent. The spans not matching any route are sent to the exporters.
+type Routing struct {
+	// Attribute is the span attribute, or the resource attribute if the span has none, e.g. tenant.id
+	Attribute string `mapstructure:"attribute"`
+	// Routes are matched in order, the first route with the attribute value wins
+	Routes []*SpanRoute `mapstructure:"routes"`
+}
+
+// SpanRoute sends the spans with one of the attribute values to its own exporter, e.g. with the tenant ID header
+type SpanRoute struct {
+	SpanExporter `mapstructure:",squash"`
+	// Values of the attribute sent to the route exporter
+	Values []string `mapstructure:"values"`
+}
+
+func (r *Routing) initDefault(c *Config, log *slog.Logger) {
+	for _, route := range r.Routes {
+		route.SpanExporter.initDefault(c, log)
+	}
+}
+
+// routingProcessor passes the ended spans to the processor of the matching route or to the fallback one
+type routingProcessor struct {
+	key      attribute.Key
+	values   map[string]int
+	routes   []sdktrace.SpanProcessor
+	fallback sdktrace.SpanProcessor
+
+	mu sync.Mutex
+	// routes of the active spans inherited from their parents
+	active map[trace.SpanID]int
+}
+
+func newRoutingProcessor(cfg *Routing, routes []sdktrace.SpanProcessor, fallback sdktrace.SpanProcessor) (*routingProcessor, error) {
+	const op = errors.Op("otel_new_routing_processor")
+
+	if cfg.Attribute == "" {
+		return nil, errors.E(op, errors.Str("routing attribute should be set"))
+	}
+
+	rp := &routingProcessor{
+		key:      attribute.Key(cfg.Attribute),
+		values:   make(map[string]int),
+		routes:   routes,
+		fallback: fallback,
+		active:   make(map[trace.SpanID]int),
+	}
+
+	for i, route := range cfg.Routes {
+		if len(route.Values) == 0 {
+			return nil, errors.E(op, errors.Errorf("route #%d: values should be set", i))
+		}
+		for _, v := range route.Values {
+			// the first route wins
+			if _, ok := rp.values[v]; !ok {
+				rp.values[v] = i
+			}
+		}
+	}
+
+	return rp, nil
+}
+
+func (rp *routingProcessor) OnStart(parent context.Context, s sdktrace.ReadWriteSpan) {
+	// the parent attributes are already set when the children start, e.g. the server span and the worker spans
+	if ps := trace.SpanFromContext(parent); ps.SpanContext().IsValid() {
+		idx, ok := -1, false
+		if ro, isRO := ps.(sdktrace.ReadOnlySpan); isRO {
+			idx, ok = rp.match(ro.Attributes())
+		}
+
+		rp.mu.Lock()
+		if !ok {
+			idx, ok = rp.active[ps.SpanContext().SpanID()]
+		}
+		if ok {
+			rp.active[s.SpanContext().SpanID()] = idx
+		}
+		rp.mu.Unlock()
+	}
+
+	for _, p := range rp.routes {
+		p.OnStart(parent, s)
+	}
+	rp.fallback.OnStart(parent, s)
+}
+
+func (rp *routingProcessor) OnEnd(s sdktrace.ReadOnlySpan) {
+	rp.mu.Lock()
+	inherited, isInherited := rp.active[s.SpanContext().SpanID()]
+	delete(rp.active, s.SpanContext().SpanID())
+	rp.mu.Unlock()
+
+	idx, ok := rp.match(s.Attributes())
+	if !ok && s.Resource() != nil {
+		idx, ok = rp.match(s.Resource().Attributes())
+	}
+	if !ok {
+		idx, ok = inherited, isInherited
+	}
+
+	if ok {
+		rp.routes[idx].OnEnd(s)
+		return
+	}
+	rp.fallback.OnEnd(s)
+}
+
+// match returns the index of the route for the attribute value, any value of the slice attributes
+func (rp *routingProcessor) match(attrs []attribute.KeyValue) (int, bool) {
+	for _, attr := range attrs {
+		if attr.Key != rp.key {
+			continue
+		}
+		if attr.Value.Type() == attribute.STRINGSLICE {
+			for _, v := range attr.Value.AsStringSlice() {
+				if idx, ok := rp.values[v]; ok {
+					return idx, true
+				}
+			}
+			return -1, false
+		}
+		idx, ok := rp.values[attr.Value.Emit()]
+		return idx, ok
+	}
+	return -1, false
+}
+
+func (rp *routingProcessor) Shutdown(ctx context.Context) error {
+	return append(fanoutProcessor{rp.fallback}, rp.routes...).Shutdown(ctx)
+}
+
+func (rp *routingProcessor) ForceFlush(ctx context.Context) error {
+	return append(fanoutProcessor{rp.fallback}, rp.routes...).ForceFlush(ctx)
+}
@@ -0,0 +1,237 @@
+package otel
+
+import (
+	"encoding/binary"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/roadrunner-server/errors"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.43.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// samplingProbabilityKey carries the effective probability the span was sampled with,
+	// backends use it to extrapolate the real number of requests
+	samplingProbabilityKey = attribute.Key("sampling.probability")
+	// adaptiveWindow is how often the adaptive sampler recomputes its probability
+	adaptiveWindow = time.Second
+)
+
+// newSampler builds the head sampler from the (already defaulted) sampler configuration.
+// The remote sampler is created by the caller, which owns its lifecycle; it's nil unless a remote type is configured.
+func newSampler(cfg *Sampler, remoteSmp *remoteSampler) (sdktrace.Sampler, error) {
+	const op = errors.Op("otel_new_sampler")
+
+	var sampler sdktrace.Sampler
+	switch cfg.Type {
+	case alwaysOn:
+		sampler = sdktrace.AlwaysSample()
+	case alwaysOff:
+		sampler = sdktrace.NeverSample()
+	case traceIDRatio:
+		sampler = sdktrace.TraceIDRatioBased(*cfg.Ratio)
+	case parentBasedAlwaysOff:
+		sampler = sdktrace.ParentBased(sdktrace.NeverSample())
+	case parentBasedTraceIDRatio:
+		sampler = sdktrace.ParentBased(sdktrace.TraceIDRatioBased(*cfg.Ratio))
+	case adaptive:
+		sampler = newAdaptiveSampler(cfg.TargetRate)
+	case parentBasedAdaptive:
+		sampler = sdktrace.ParentBased(newAdaptiveSampler(cfg.TargetRate))
+	case remote:
+		sampler = remoteSmp
+	case parentBasedRemote:
+		sampler = sdktrace.ParentBased(remoteSmp)
+	case parentBasedAlwaysOn:
+		sampler = sdktrace.ParentBased(sdktrace.AlwaysSample())
+	default:
+		// should not happen, InitDefault resets unknown values
+		sampler = sdktrace.ParentBased(sdktrace.AlwaysSample())
+	}
+
+	if len(cfg.Rules) == 0 {
+		return sampler, nil
+	}
+
+	rs := &ruleSampler{
+		rules:    make([]*compiledRule, 0, len(cfg.Rules)),
+		fallback: sampler,
+	}
+	for i, rule := range cfg.Rules {
+		cr := &compiledRule{
+			method:     strings.ToUpper(rule.Method),
+			pathPrefix: rule.PathPrefix,
+			host:       strings.ToLower(rule.Host),
+			sampler:    sdktrace.TraceIDRatioBased(rule.Ratio),
+		}
+		if rule.PathRegex != "" {
+			re, err := regexp.Compile(rule.PathRegex)
+			if err != nil {
+				return nil, errors.E(op, errors.Errorf("sampling rule #%d: %v", i, err))
+			}
+			cr.pathRegex = re
+		}
+		rs.rules = append(rs.rules, cr)
+	}
+
+	return rs, nil
+}
+
+type compiledRule struct {
+	method     string
+	pathPrefix string
+	pathRegex  *regexp.Regexp
+	host       string
+	sampler    sdktrace.Sampler
+}
+
+// match reports whether the request attributes satisfy all the non-empty rule criteria.
+func (r *compiledRule) match(method, path, host string) bool {
+	if r.method != "" && r.method != method {
+		return false
+	}
+	if r.pathPrefix != "" && !strings.HasPrefix(path, r.pathPrefix) {
+		return false
+	}
+	if r.pathRegex != nil && !r.pathRegex.MatchString(path) {
+		return false
+	}
+	if r.host != "" && r.host != strings.ToLower(host) {
+		return false
+	}
+	return true
+}
+
+// ruleSampler samples the root HTTP server spans by the first matching rule. The request
+// properties are taken from the span start attributes set by otelhttp, spans without
+// a matching rule (including the non-server ones, e.g. the otelhttp client spans, and
+// the ones continuing an upstream trace) are delegated to the fallback sampler.
+type ruleSampler struct {
+	rules    []*compiledRule
+	fallback sdktrace.Sampler
+}
+
+func (s *ruleSampler) ShouldSample(p sdktrace.SamplingParameters) sdktrace.SamplingResult {
+	// the upstream decision is kept as configured by the fallback, e.g. parentbased_*
+	if p.Kind != trace.SpanKindServer || trace.SpanContextFromContext(p.ParentContext).IsValid() {
+		return s.fallback.ShouldSample(p)
+	}
+
+	var method, path, host string
+	var isHTTP bool
+	for _, attr := range p.Attributes {
+		switch attr.Key {
+		case semconv.HTTPRequestMethodKey:
+			method, isHTTP = attr.Value.AsString(), true
+		case semconv.URLPathKey:
+			path = attr.Value.AsString()
+		case semconv.ServerAddressKey:
+			host = attr.Value.AsString()
+		}
+	}
+
+	if isHTTP {
+		for _, rule := range s.rules {
+			if rule.match(method, path, host) {
+				return rule.sampler.ShouldSample(p)
+			}
+		}
+	}
+
+	return s.fallback.ShouldSample(p)
+}
+
+func (s *ruleSampler) Description() string {
+	return fmt.Sprintf("RuleBased{rules:%d,fallback:%s}", len(s.rules), s.fallback.Description())
+}
+
+// adaptiveSampler samples the traces with a probability adjusted every adaptiveWindow so the number
+// of sampled traces per second stays close to the target rate. Like TraceIDRatioBased, the decision
+// is derived from the trace ID.
+type adaptiveSampler struct {
+	target float64
+
+	mu          sync.Mutex
+	windowStart time.Time
+	seen        uint64
+	rate        float64
+	probability float64
+}
+
+func newAdaptiveSampler(target float64) *adaptiveSampler {
+	return &adaptiveSampler{
+		target:      target,
+		windowStart: time.Now(),
+		probability: 1,
+	}
+}
+
+func (s *adaptiveSampler) ShouldSample(p sdktrace.SamplingParameters) sdktrace.SamplingResult {
+	psc := trace.SpanContextFromContext(p.ParentContext)
+
+	// only the root spans are counted as traces, the children follow the decision of their parent
+	if psc.IsValid() {
+		decision := sdktrace.Drop
+		if psc.IsSampled() {
+			decision = sdktrace.RecordAndSample
+		}
+		return sdktrace.SamplingResult{
+			Decision:   decision,
+			Tracestate: psc.TraceState(),
+		}
+	}
+
+	probability := s.observe(time.Now())
+
+	// same algorithm as in the TraceIDRatioBased sampler
+	bound := uint64(probability * (1 << 63))
+	if binary.BigEndian.Uint64(p.TraceID[8:16])>>1 >= bound {
+		return sdktrace.SamplingResult{
+			Decision:   sdktrace.Drop,
+			Tracestate: psc.TraceState(),
+		}
+	}
+
+	return sdktrace.SamplingResult{
+		Decision:   sdktrace.RecordAndSample,
+		Attributes: []attribute.KeyValue{samplingProbabilityKey.Float64(probability)},
+		Tracestate: psc.TraceState(),
+	}
+}
+
+// observe counts the trace and returns the current probability, recomputing it when the window is over
+func (s *adaptiveSampler) observe(now time.Time) float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if elapsed := now.Sub(s.windowStart); elapsed >= adaptiveWindow {
+		observed := float64(s.seen) / elapsed.Seconds()
+		// smooth the rate, so a single burst or a quiet second does not swing the probability
+		if s.rate == 0 {
+			s.rate = observed
+		} else {
+			s.rate = (s.rate + observed) / 2
+		}
+
+		s.probability = 1
+		if s.rate > s.target {
+			s.probability = s.target / s.rate
+		}
+
+		s.seen = 0
+		s.windowStart = now
+	}
+
+	s.seen++
+	return s.probability
+}
+
+func (s *adaptiveSampler) Description() string {
+	return fmt.Sprintf("Adaptive{target:%g}", s.target)
+}
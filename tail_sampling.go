@@ -0,0 +1,227 @@
+package otel
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TailSampling buffers the finished spans per trace and decides whether to export the whole trace
+// once the decision window is over. A trace is kept if it matches any of the policies.
+type TailSampling struct {
+	// DecisionWait is the time to wait after the first finished span of a trace before deciding. Defaults to 10s
+	DecisionWait time.Duration `mapstructure:"decision_wait"`
+	// MaxTraces is the maximum number of traces held in memory, new traces are dropped when it's reached. Defaults to 10000
+	MaxTraces int `mapstructure:"max_traces"`
+	// MaxSpansPerTrace is the maximum number of spans buffered per trace, the rest are dropped. Defaults to 1000
+	MaxSpansPerTrace int `mapstructure:"max_spans_per_trace"`
+	// Errors keeps the traces having at least one span with the error status
+	Errors bool `mapstructure:"errors"`
+	// MinDuration keeps the traces lasting at least this long
+	MinDuration time.Duration `mapstructure:"min_duration"`
+	// Attributes keeps the traces having at least one span with any of these attribute values
+	Attributes map[string]string `mapstructure:"attributes"`
+	// Ratio of the remaining traces to keep, must be in the [0, 1] range
+	Ratio float64 `mapstructure:"ratio"`
+}
+
+type tracePending struct {
+	spans    []sdktrace.ReadOnlySpan
+	deadline time.Time
+}
+
+// tailSampler is a span processor sitting in front of the exporting span processor
+type tailSampler struct {
+	cfg      *TailSampling
+	next     sdktrace.SpanProcessor
+	baseline sdktrace.Sampler
+
+	mu      sync.Mutex
+	pending map[trace.TraceID]*tracePending
+	// decided remembers the last MaxTraces decisions, so the spans ending after the decision
+	// follow the rest of their trace. decidedRing is the eviction order.
+	decided     map[trace.TraceID]bool
+	decidedRing []trace.TraceID
+	decidedNext int
+	dropped     atomic.Uint64
+
+	stopOnce sync.Once
+	stopCh   chan struct{}
+	wg       sync.WaitGroup
+}
+
+func newTailSampler(cfg *TailSampling, next sdktrace.SpanProcessor) *tailSampler {
+	ts := &tailSampler{
+		cfg:         cfg,
+		next:        next,
+		baseline:    sdktrace.TraceIDRatioBased(cfg.Ratio),
+		pending:     make(map[trace.TraceID]*tracePending, cfg.MaxTraces),
+		decided:     make(map[trace.TraceID]bool, cfg.MaxTraces),
+		decidedRing: make([]trace.TraceID, 0, cfg.MaxTraces),
+		stopCh:      make(chan struct{}),
+	}
+
+	ts.wg.Add(1)
+	go ts.loop()
+
+	return ts
+}
+
+func (ts *tailSampler) OnStart(parent context.Context, s sdktrace.ReadWriteSpan) {
+	ts.next.OnStart(parent, s)
+}
+
+func (ts *tailSampler) OnEnd(s sdktrace.ReadOnlySpan) {
+	if !s.SpanContext().IsSampled() {
+		return
+	}
+
+	traceID := s.SpanContext().TraceID()
+
+	ts.mu.Lock()
+
+	// the trace was already decided, the late span follows the decision
+	if keep, ok := ts.decided[traceID]; ok {
+		ts.mu.Unlock()
+		if keep {
+			ts.next.OnEnd(s)
+		}
+		return
+	}
+
+	defer ts.mu.Unlock()
+
+	tp, ok := ts.pending[traceID]
+	if !ok {
+		if len(ts.pending) >= ts.cfg.MaxTraces {
+			// rejected as a whole, the rest of its spans are dropped without being counted again
+			ts.dropped.Add(1)
+			ts.remember(traceID, false)
+			return
+		}
+		tp = &tracePending{deadline: time.Now().Add(ts.cfg.DecisionWait)}
+		ts.pending[traceID] = tp
+	}
+	if len(tp.spans) >= ts.cfg.MaxSpansPerTrace {
+		return
+	}
+	tp.spans = append(tp.spans, s)
+}
+
+// remember records the decision for the trace, evicting the oldest one when the cache is full.
+// Must be called under the lock.
+func (ts *tailSampler) remember(traceID trace.TraceID, keep bool) {
+	if len(ts.decidedRing) < ts.cfg.MaxTraces {
+		ts.decidedRing = append(ts.decidedRing, traceID)
+	} else {
+		delete(ts.decided, ts.decidedRing[ts.decidedNext])
+		ts.decidedRing[ts.decidedNext] = traceID
+		ts.decidedNext = (ts.decidedNext + 1) % ts.cfg.MaxTraces
+	}
+	ts.decided[traceID] = keep
+}
+
+func (ts *tailSampler) Shutdown(ctx context.Context) error {
+	ts.stopOnce.Do(func() {
+		close(ts.stopCh)
+	})
+	ts.wg.Wait()
+
+	// decide on everything still buffered, the traces are incomplete, but it's better than losing them
+	ts.decide(time.Time{})
+	return ts.next.Shutdown(ctx)
+}
+
+func (ts *tailSampler) ForceFlush(ctx context.Context) error {
+	ts.decide(time.Time{})
+	return ts.next.ForceFlush(ctx)
+}
+
+// Dropped returns the number of traces dropped because MaxTraces was reached
+func (ts *tailSampler) Dropped() uint64 {
+	return ts.dropped.Load()
+}
+
+func (ts *tailSampler) loop() {
+	defer ts.wg.Done()
+
+	ticker := time.NewTicker(max(ts.cfg.DecisionWait/10, 10*time.Millisecond))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ts.stopCh:
+			return
+		case now := <-ticker.C:
+			ts.decide(now)
+		}
+	}
+}
+
+// decide evaluates the traces whose decision window ended before now (all of them if now is zero)
+// and forwards the spans of the kept ones to the next processor.
+func (ts *tailSampler) decide(now time.Time) {
+	var kept [][]sdktrace.ReadOnlySpan
+
+	// the decision is recorded together with removing the trace from pending,
+	// so a span ending in between can't start the trace over
+	ts.mu.Lock()
+	for traceID, tp := range ts.pending {
+		if !now.IsZero() && now.Before(tp.deadline) {
+			continue
+		}
+		delete(ts.pending, traceID)
+		keep := ts.keep(tp.spans)
+		ts.remember(traceID, keep)
+		if keep {
+			kept = append(kept, tp.spans)
+		}
+	}
+	ts.mu.Unlock()
+
+	for _, spans := range kept {
+		for _, s := range spans {
+			ts.next.OnEnd(s)
+		}
+	}
+}
+
+func (ts *tailSampler) keep(spans []sdktrace.ReadOnlySpan) bool {
+	var start, end time.Time
+	for _, s := range spans {
+		if ts.cfg.Errors && s.Status().Code == codes.Error {
+			return true
+		}
+		if len(ts.cfg.Attributes) > 0 && matchAttributes(s.Attributes(), ts.cfg.Attributes) {
+			return true
+		}
+		if start.IsZero() || s.StartTime().Before(start) {
+			start = s.StartTime()
+		}
+		if s.EndTime().After(end) {
+			end = s.EndTime()
+		}
+	}
+
+	if ts.cfg.MinDuration > 0 && end.Sub(start) >= ts.cfg.MinDuration {
+		return true
+	}
+
+	res := ts.baseline.ShouldSample(sdktrace.SamplingParameters{TraceID: spans[0].SpanContext().TraceID()})
+	return res.Decision == sdktrace.RecordAndSample
+}
+
+func matchAttributes(attrs []attribute.KeyValue, want map[string]string) bool {
+	for _, attr := range attrs {
+		if val, ok := want[string(attr.Key)]; ok && attr.Value.Emit() == val {
+			return true
+		}
+	}
+	return false
+}
@@ -1,9 +1,11 @@
 package tests
 
 import (
+	"bytes"
 	"io"
 	"log/slog"
 	"testing"
+	"time"
 
 	"github.com/roadrunner-server/otel/v6"
 	"github.com/stretchr/testify/require"
@@ -44,6 +46,7 @@ func TestConfig_ClientSelectionFromEnv(t *testing.T) {
 		{"traces protocol wins over generic", "grpc", "http/protobuf", otel.Client("grpc")},
 		{"generic http fallback", "", "http/protobuf", otel.Client("http")},
 		{"generic grpc fallback", "", "grpc", otel.Client("grpc")},
+		{"traces json", "http/json", "grpc", otel.Client("http/json")},
 	}
 
 	for _, tc := range cases {
@@ -81,3 +84,184 @@ func TestConfig_ResourceValuePrecedence(t *testing.T) {
 	require.Equal(t, "explicit-name", explicit.Resource.ServiceNameKey, "explicit resource value must win")
 	require.Equal(t, "1.0.0", explicit.Resource.ServiceVersionKey, "unset version must fall back to default")
 }
+
+// TestConfig_SamplerSelection verifies the sampler resolution: an explicit
+// sampler type wins over the OTEL_TRACES_SAMPLER* environment variables, which
+// in turn win over the parentbased_always_on default.
+func TestConfig_SamplerSelection(t *testing.T) {
+	cases := []struct {
+		name      string
+		sampler   *otel.Sampler
+		env       string // OTEL_TRACES_SAMPLER
+		envArg    string // OTEL_TRACES_SAMPLER_ARG
+		wantType  otel.SamplerType
+		wantRatio float64
+	}{
+		{"default", nil, "", "", otel.SamplerType("parentbased_always_on"), 1.0},
+		{"from env", nil, "traceidratio", "0.25", otel.SamplerType("traceidratio"), 0.25},
+		{"invalid env arg", nil, "traceidratio", "abc", otel.SamplerType("traceidratio"), 1.0},
+		{"unknown env sampler", nil, "bogus", "0.5", otel.SamplerType("parentbased_always_on"), 1.0},
+		{"config wins over env", &otel.Sampler{Type: "always_off"}, "traceidratio", "0.25", otel.SamplerType("always_off"), 1.0},
+		{"config ratio wins over env arg", &otel.Sampler{Ratio: ptr(0.1)}, "traceidratio", "0.25", otel.SamplerType("traceidratio"), 0.1},
+		{"out of range ratio", &otel.Sampler{Type: "traceidratio", Ratio: ptr(5.0)}, "", "", otel.SamplerType("traceidratio"), 1.0},
+		{"unknown config type", &otel.Sampler{Type: "bogus"}, "", "", otel.SamplerType("parentbased_always_on"), 1.0},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv("OTEL_TRACES_SAMPLER", tc.env)
+			t.Setenv("OTEL_TRACES_SAMPLER_ARG", tc.envArg)
+
+			cfg := &otel.Config{Sampler: tc.sampler}
+			cfg.InitDefault(discardLogger())
+			require.Equal(t, tc.wantType, cfg.Sampler.Type)
+			require.NotNil(t, cfg.Sampler.Ratio)
+			require.InDelta(t, tc.wantRatio, *cfg.Sampler.Ratio, 1e-9)
+		})
+	}
+}
+
+func ptr[T any](v T) *T { return &v }
+
+// TestConfig_TailSamplingDefaults verifies the tail sampling limits are filled
+// in only when the section is present.
+func TestConfig_TailSamplingDefaults(t *testing.T) {
+	disabled := &otel.Config{}
+	disabled.InitDefault(discardLogger())
+	require.Nil(t, disabled.TailSampling)
+
+	enabled := &otel.Config{TailSampling: &otel.TailSampling{Ratio: 2}}
+	enabled.InitDefault(discardLogger())
+	require.Equal(t, 10*time.Second, enabled.TailSampling.DecisionWait)
+	require.Equal(t, 10000, enabled.TailSampling.MaxTraces)
+	require.Equal(t, 1000, enabled.TailSampling.MaxSpansPerTrace)
+	require.Zero(t, enabled.TailSampling.Ratio, "out of range ratio must be reset")
+}
+
+// TestConfig_TailSamplingNoPolicies verifies a warning is logged when the tail
+// sampling would drop every trace: no policies and the default ratio of 0.
+func TestConfig_TailSamplingNoPolicies(t *testing.T) {
+	var buf bytes.Buffer
+	cfg := &otel.Config{TailSampling: &otel.TailSampling{}}
+	cfg.InitDefault(slog.New(slog.NewTextHandler(&buf, nil)))
+	require.Contains(t, buf.String(), "all the traces are dropped")
+
+	buf.Reset()
+	cfg = &otel.Config{TailSampling: &otel.TailSampling{Errors: true}}
+	cfg.InitDefault(slog.New(slog.NewTextHandler(&buf, nil)))
+	require.NotContains(t, buf.String(), "all the traces are dropped")
+}
+
+// TestConfig_RemoteSamplerFromEnv verifies the jaeger_remote sampler and its
+// arguments are taken from the environment when no sampler type is configured.
+func TestConfig_RemoteSamplerFromEnv(t *testing.T) {
+	t.Setenv("OTEL_TRACES_SAMPLER", "parentbased_jaeger_remote")
+	t.Setenv("OTEL_TRACES_SAMPLER_ARG", "endpoint=http://sampling:5778/sampling, pollingIntervalMs=5000, initialSamplingRate=0.25")
+
+	cfg := &otel.Config{}
+	cfg.InitDefault(discardLogger())
+	require.Equal(t, otel.SamplerType("parentbased_remote"), cfg.Sampler.Type)
+	require.Equal(t, "http://sampling:5778/sampling", cfg.Sampler.Remote.URL)
+	require.Equal(t, 5*time.Second, cfg.Sampler.Remote.PollingInterval)
+	require.Equal(t, 256, cfg.Sampler.Remote.MaxOperations)
+	require.InDelta(t, 0.25, *cfg.Sampler.Ratio, 1e-9)
+}
+
+// TestConfig_MetricsInheritance verifies the metrics section inherits the
+// unset exporter and connection options from the traces configuration, and
+// that the metrics protocol env variable only affects the metrics client.
+func TestConfig_MetricsInheritance(t *testing.T) {
+	t.Setenv("OTEL_EXPORTER_OTLP_TRACES_PROTOCOL", "")
+	t.Setenv("OTEL_EXPORTER_OTLP_PROTOCOL", "")
+	t.Setenv("OTEL_EXPORTER_OTLP_METRICS_PROTOCOL", "grpc")
+
+	cfg := &otel.Config{
+		Endpoint:  "collector:4318",
+		CustomURL: "/custom/traces",
+		Insecure:  true,
+		Headers:   map[string]string{"x-key": "value"},
+		Metrics:   &otel.Metrics{SignalExporter: otel.SignalExporter{Compress: ptr(true)}},
+	}
+	cfg.InitDefault(discardLogger())
+
+	require.Equal(t, otel.Client("http"), cfg.Client)
+	require.Equal(t, otel.Client("grpc"), cfg.Metrics.Client)
+	require.Equal(t, otel.Exporter("otlp"), cfg.Metrics.Exporter)
+	require.Equal(t, "collector:4318", cfg.Metrics.Endpoint)
+	require.Empty(t, cfg.Metrics.CustomURL, "the traces URL path must not be inherited")
+	require.True(t, *cfg.Metrics.Insecure)
+	require.True(t, *cfg.Metrics.Compress)
+	require.Equal(t, cfg.Headers, cfg.Metrics.Headers)
+	require.Equal(t, time.Minute, cfg.Metrics.Interval)
+}
+
+// TestConfig_FileExporterNotInherited verifies the metrics and logs sections fall
+// back to otlp instead of inheriting the traces-only file exporter.
+func TestConfig_FileExporterNotInherited(t *testing.T) {
+	cfg := &otel.Config{
+		Exporter: otel.Exporter("file"),
+		Metrics:  &otel.Metrics{},
+		Logs:     &otel.Logs{},
+	}
+	cfg.InitDefault(discardLogger())
+
+	require.Equal(t, otel.Exporter("file"), cfg.Exporter)
+	require.Equal(t, otel.Exporter("otlp"), cfg.Metrics.Exporter)
+	require.Equal(t, otel.Exporter("otlp"), cfg.Logs.Exporter)
+}
+
+// TestConfig_PropagatorsSelection verifies the propagators list precedence:
+// config, then OTEL_PROPAGATORS, then the built-in default.
+func TestConfig_PropagatorsSelection(t *testing.T) {
+	t.Setenv("OTEL_PROPAGATORS", "")
+	def := &otel.Config{}
+	def.InitDefault(discardLogger())
+	require.Equal(t, []string{"tracecontext", "baggage", "jaeger"}, def.Propagators)
+
+	t.Setenv("OTEL_PROPAGATORS", "b3, xray")
+	env := &otel.Config{}
+	env.InitDefault(discardLogger())
+	require.Equal(t, []string{"b3", "xray"}, env.Propagators)
+
+	explicit := &otel.Config{Propagators: []string{"ottrace"}}
+	explicit.InitDefault(discardLogger())
+	require.Equal(t, []string{"ottrace"}, explicit.Propagators)
+}
+
+// TestConfig_ExportersInheritance verifies the top-level exporter is used when
+// the exporters list is empty, and that the list entries inherit the empty options.
+func TestConfig_ExportersInheritance(t *testing.T) {
+	t.Setenv("OTEL_EXPORTER_OTLP_TRACES_PROTOCOL", "")
+	t.Setenv("OTEL_EXPORTER_OTLP_PROTOCOL", "")
+
+	single := &otel.Config{Exporter: "stdout"}
+	single.InitDefault(discardLogger())
+	require.Len(t, single.Exporters, 1)
+	require.Equal(t, otel.Exporter("stdout"), single.Exporters[0].Exporter)
+
+	cfg := &otel.Config{
+		Client:   "grpc",
+		Endpoint: "old-collector:4317",
+		Headers:  map[string]string{"x-key": "value"},
+		Exporters: []*otel.SpanExporter{
+			{},
+			{SignalExporter: otel.SignalExporter{Client: "http", Endpoint: "new-collector:4318", Insecure: ptr(true)}},
+			{SignalExporter: otel.SignalExporter{Exporter: "file"}},
+		},
+	}
+	cfg.InitDefault(discardLogger())
+
+	require.Len(t, cfg.Exporters, 3)
+	require.Equal(t, otel.Exporter("otlp"), cfg.Exporters[0].Exporter)
+	require.Equal(t, otel.Client("grpc"), cfg.Exporters[0].Client)
+	require.Equal(t, "old-collector:4317", cfg.Exporters[0].Endpoint)
+	require.False(t, *cfg.Exporters[0].Insecure)
+
+	require.Equal(t, otel.Client("http"), cfg.Exporters[1].Client)
+	require.Equal(t, "new-collector:4318", cfg.Exporters[1].Endpoint)
+	require.True(t, *cfg.Exporters[1].Insecure)
+	require.Equal(t, cfg.Headers, cfg.Exporters[1].Headers)
+
+	require.NotNil(t, cfg.Exporters[2].File)
+	require.Equal(t, "rr-traces.jsonl", cfg.Exporters[2].File.Path)
+}
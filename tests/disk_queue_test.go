@@ -0,0 +1,219 @@
+package tests
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/roadrunner-server/otel/v6"
+	"github.com/stretchr/testify/require"
+	otelapi "go.opentelemetry.io/otel"
+)
+
+// flakyCollector returns a collector answering 503 until up is set and counting the accepted requests.
+func flakyCollector(t *testing.T, up *atomic.Bool, accepted *atomic.Int64) *httptest.Server {
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if !up.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		accepted.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte("{}"))
+	}))
+	t.Cleanup(collector.Close)
+	return collector
+}
+
+func queueConfig(endpoint, dir string) *otel.Config {
+	return &otel.Config{
+		Exporter: otel.Exporter("otlp"),
+		Client:   otel.Client("http/json"),
+		Endpoint: strings.TrimPrefix(endpoint, "http://"),
+		Insecure: true,
+		Sampler:  &otel.Sampler{Type: "always_on"},
+		Queue:    &otel.Queue{Dir: dir, RetryInterval: 50 * time.Millisecond},
+	}
+}
+
+// TestDiskQueue_Replay verifies the batches are kept on disk while the collector
+// is unavailable and sent once it's back.
+func TestDiskQueue_Replay(t *testing.T) {
+	var up atomic.Bool
+	var accepted atomic.Int64
+	collector := flakyCollector(t, &up, &accepted)
+
+	p := &otel.Plugin{}
+	require.NoError(t, p.Init(newConfigurer(queueConfig(collector.URL, t.TempDir())), mockLogger{}))
+
+	for range 3 {
+		_, span := otelapi.Tracer("test").Start(context.Background(), "queued")
+		span.End()
+		require.NoError(t, p.Tracer().ForceFlush(context.Background()))
+	}
+
+	batches, size := p.QueueBacklog()
+	require.Equal(t, 3, batches)
+	require.Positive(t, size)
+	require.Zero(t, accepted.Load())
+
+	up.Store(true)
+	require.Eventually(t, func() bool {
+		batches, _ := p.QueueBacklog()
+		return batches == 0
+	}, 5*time.Second, 20*time.Millisecond)
+	require.Equal(t, int64(3), accepted.Load())
+
+	require.NoError(t, p.Stop(context.Background()))
+}
+
+// TestDiskQueue_Restart verifies the batches left by a stopped plugin are replayed
+// by the next one using the same directory.
+func TestDiskQueue_Restart(t *testing.T) {
+	var up atomic.Bool
+	var accepted atomic.Int64
+	collector := flakyCollector(t, &up, &accepted)
+	dir := t.TempDir()
+
+	p := &otel.Plugin{}
+	require.NoError(t, p.Init(newConfigurer(queueConfig(collector.URL, dir)), mockLogger{}))
+
+	_, span := otelapi.Tracer("test").Start(context.Background(), "persisted")
+	span.End()
+	require.NoError(t, p.Stop(context.Background()))
+	require.Zero(t, accepted.Load())
+
+	up.Store(true)
+
+	next := &otel.Plugin{}
+	require.NoError(t, next.Init(newConfigurer(queueConfig(collector.URL, dir)), mockLogger{}))
+	t.Cleanup(func() {
+		_ = next.Stop(context.Background())
+	})
+
+	require.Eventually(t, func() bool {
+		return accepted.Load() == 1
+	}, 5*time.Second, 20*time.Millisecond)
+	batches, _ := next.QueueBacklog()
+	require.Zero(t, batches)
+}
+
+// TestDiskQueue_RejectedBatch verifies a batch rejected by the backend with a 4xx
+// status is dropped and does not block the batches queued after it.
+func TestDiskQueue_RejectedBatch(t *testing.T) {
+	for _, client := range []string{"http", "http/json"} {
+		t.Run(client, func(t *testing.T) {
+			var accepted atomic.Int64
+			collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+				if strings.Contains(string(body), "poison") {
+					w.WriteHeader(http.StatusBadRequest)
+					return
+				}
+				accepted.Add(1)
+				w.WriteHeader(http.StatusOK)
+			}))
+			t.Cleanup(collector.Close)
+
+			cfg := queueConfig(collector.URL, t.TempDir())
+			cfg.Client = otel.Client(client)
+
+			p := &otel.Plugin{}
+			require.NoError(t, p.Init(newConfigurer(cfg), mockLogger{}))
+			t.Cleanup(func() {
+				_ = p.Stop(context.Background())
+			})
+
+			for _, name := range []string{"poison", "valid"} {
+				_, span := otelapi.Tracer("test").Start(context.Background(), name)
+				span.End()
+				require.NoError(t, p.Tracer().ForceFlush(context.Background()))
+			}
+
+			require.Eventually(t, func() bool {
+				batches, _ := p.QueueBacklog()
+				return batches == 0 && accepted.Load() == 1
+			}, 5*time.Second, 20*time.Millisecond)
+		})
+	}
+}
+
+// TestDiskQueue_Dirs verifies the queue directory is required and the exporters
+// sharing a directory fail Plugin.Init.
+func TestDiskQueue_Dirs(t *testing.T) {
+	fileExporter := func(dir string) *otel.SpanExporter {
+		return &otel.SpanExporter{
+			SignalExporter: otel.SignalExporter{Exporter: "file"},
+			File:           &otel.File{Path: filepath.Join(t.TempDir(), "traces.jsonl")},
+			Queue:          &otel.Queue{Dir: dir},
+		}
+	}
+
+	p := &otel.Plugin{}
+	err := p.Init(newConfigurer(&otel.Config{
+		Exporters: []*otel.SpanExporter{fileExporter("")},
+	}), mockLogger{})
+	require.Error(t, err)
+
+	shared := filepath.Join(t.TempDir(), "queue")
+	p = &otel.Plugin{}
+	err = p.Init(newConfigurer(&otel.Config{
+		Exporters: []*otel.SpanExporter{fileExporter(shared)},
+		Routing: &otel.Routing{Attribute: "tenant.id", Routes: []*otel.SpanRoute{
+			{SpanExporter: *fileExporter(shared + "/"), Values: []string{"a"}},
+		}},
+	}), mockLogger{})
+	require.Error(t, err)
+}
+
+// TestDiskQueue_Outage verifies the batches are kept while the collector is unavailable,
+// the first batch is retried every retry_interval only, and the queue files are private.
+func TestDiskQueue_Outage(t *testing.T) {
+	var requests atomic.Int64
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(collector.Close)
+
+	dir := filepath.Join(t.TempDir(), "queue")
+	cfg := queueConfig(collector.URL, dir)
+	cfg.Queue.RetryInterval = time.Hour
+
+	p := &otel.Plugin{}
+	require.NoError(t, p.Init(newConfigurer(cfg), mockLogger{}))
+	t.Cleanup(func() {
+		_ = p.Stop(context.Background())
+	})
+
+	for range 5 {
+		_, span := otelapi.Tracer("test").Start(context.Background(), "queued")
+		span.End()
+		require.NoError(t, p.Tracer().ForceFlush(context.Background()))
+	}
+	time.Sleep(200 * time.Millisecond)
+
+	batches, _ := p.QueueBacklog()
+	require.Equal(t, 5, batches)
+	// the first attempt, and one more if a batch arrived while it was in flight
+	require.LessOrEqual(t, requests.Load(), int64(2))
+
+	info, err := os.Stat(dir)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0o700), info.Mode().Perm())
+	files, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, files, 5)
+	for _, f := range files {
+		info, err = f.Info()
+		require.NoError(t, err)
+		require.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+	}
+}
@@ -0,0 +1,59 @@
+package tests
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/roadrunner-server/otel/v6"
+	"github.com/stretchr/testify/require"
+)
+
+// TestExporters_FanOut verifies every configured exporter receives all the
+// spans, with and without the tail sampler in front of them.
+func TestExporters_FanOut(t *testing.T) {
+	cases := []struct {
+		name string
+		tail bool
+	}{
+		{"direct", false},
+		{"tail sampling", true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			first, second := filepath.Join(dir, "first.jsonl"), filepath.Join(dir, "second.jsonl")
+
+			cfg := &otel.Config{
+				Sampler: &otel.Sampler{Type: "always_on"},
+				Exporters: []*otel.SpanExporter{
+					{SignalExporter: otel.SignalExporter{Exporter: "file"}, File: &otel.File{Path: first}},
+					{SignalExporter: otel.SignalExporter{Exporter: "file"}, File: &otel.File{Path: second}, Batch: &otel.Batch{MaxExportBatchSize: 1}},
+				},
+			}
+			if tc.tail {
+				cfg.TailSampling = &otel.TailSampling{DecisionWait: time.Millisecond, Ratio: 1}
+			}
+
+			p := &otel.Plugin{}
+			require.NoError(t, p.Init(newConfigurer(cfg), mockLogger{}))
+
+			ctx := context.Background()
+			for range 3 {
+				_, span := p.Tracer().Tracer("test").Start(ctx, "fanout")
+				span.End()
+			}
+			require.NoError(t, p.Stop(ctx))
+
+			for _, path := range []string{first, second} {
+				data, err := os.ReadFile(path)
+				require.NoError(t, err)
+				require.Equal(t, 3, strings.Count(string(data), `"name":"fanout"`), path)
+			}
+		})
+	}
+}
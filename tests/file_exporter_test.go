@@ -0,0 +1,102 @@
+package tests
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/roadrunner-server/otel/v6"
+	"github.com/stretchr/testify/require"
+)
+
+// TestFileExporter_Lines verifies every exported batch is appended to the file
+// as an OTLP JSON line.
+func TestFileExporter_Lines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "traces", "rr.jsonl")
+
+	p := &otel.Plugin{}
+	require.NoError(t, p.Init(newConfigurer(&otel.Config{
+		Exporter: otel.Exporter("file"),
+		File:     &otel.File{Path: path, Fsync: "batch"},
+		Sampler:  &otel.Sampler{Type: "always_on"},
+	}), mockLogger{}))
+
+	ctx := context.Background()
+	for _, name := range []string{"first", "second"} {
+		_, span := p.Tracer().Tracer("test").Start(ctx, name)
+		span.End()
+		require.NoError(t, p.Tracer().ForceFlush(ctx))
+	}
+	require.NoError(t, p.Stop(ctx))
+
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = f.Close() })
+
+	var names []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var req struct {
+			ResourceSpans []struct {
+				ScopeSpans []struct {
+					Spans []struct {
+						TraceID string `json:"traceId"`
+						Name    string `json:"name"`
+					} `json:"spans"`
+				} `json:"scopeSpans"`
+			} `json:"resourceSpans"`
+		}
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &req))
+		span := req.ResourceSpans[0].ScopeSpans[0].Spans[0]
+		require.Len(t, span.TraceID, 32)
+		names = append(names, span.Name)
+	}
+	require.NoError(t, scanner.Err())
+	require.Equal(t, []string{"first", "second"}, names)
+}
+
+// TestFileExporter_Rotation verifies the file is rotated by age, the rotated
+// files are compressed and only max_backups of them are kept.
+func TestFileExporter_Rotation(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "rr.jsonl")
+
+	p := &otel.Plugin{}
+	require.NoError(t, p.Init(newConfigurer(&otel.Config{
+		Exporter: otel.Exporter("file"),
+		File:     &otel.File{Path: path, MaxAge: time.Nanosecond, MaxBackups: 2, Compress: true},
+		Sampler:  &otel.Sampler{Type: "always_on"},
+	}), mockLogger{}))
+
+	ctx := context.Background()
+	for range 5 {
+		_, span := p.Tracer().Tracer("test").Start(ctx, "rotated")
+		span.End()
+		require.NoError(t, p.Tracer().ForceFlush(ctx))
+	}
+	require.NoError(t, p.Stop(ctx))
+
+	backups, err := filepath.Glob(filepath.Join(dir, "rr-*.jsonl.gz"))
+	require.NoError(t, err)
+	require.Len(t, backups, 2)
+
+	f, err := os.Open(backups[0])
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = f.Close() })
+
+	gz, err := gzip.NewReader(f)
+	require.NoError(t, err)
+	line, err := bufio.NewReader(gz).ReadString('\n')
+	require.NoError(t, err)
+	require.True(t, strings.Contains(line, `"name":"rotated"`), line)
+
+	current, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, 1, strings.Count(string(current), "\n"))
+}
@@ -0,0 +1,691 @@
+package tests
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/roadrunner-server/otel/v6"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// newRecordedPlugin initializes the plugin with the stdout exporter and attaches
+// a span recorder to its tracer provider, so the server spans can be asserted.
+func newRecordedPlugin(t *testing.T, cfg *otel.Config) (*otel.Plugin, *tracetest.SpanRecorder) {
+	t.Helper()
+
+	cfg.Exporter = otel.Exporter("stdout")
+	if cfg.Sampler == nil {
+		cfg.Sampler = &otel.Sampler{Type: "always_on"}
+	}
+
+	p := &otel.Plugin{}
+	require.NoError(t, p.Init(newConfigurer(cfg), mockLogger{}))
+	t.Cleanup(func() { _ = p.Stop(context.Background()) })
+
+	rec := tracetest.NewSpanRecorder()
+	p.Tracer().RegisterSpanProcessor(rec)
+	return p, rec
+}
+
+// lastSpan returns the last ended span
+func lastSpan(t *testing.T, rec *tracetest.SpanRecorder) sdktrace.ReadOnlySpan {
+	t.Helper()
+
+	spans := rec.Ended()
+	require.NotEmpty(t, spans)
+	return spans[len(spans)-1]
+}
+
+func spanAttr(span sdktrace.ReadOnlySpan, key attribute.Key) (attribute.Value, bool) {
+	for _, attr := range span.Attributes() {
+		if attr.Key == key {
+			return attr.Value, true
+		}
+	}
+	return attribute.Value{}, false
+}
+
+func okHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+}
+
+// TestHTTP_RouteTemplates verifies the server span name and the http.route
+// attribute come from the configured route templates.
+func TestHTTP_RouteTemplates(t *testing.T) {
+	p, rec := newRecordedPlugin(t, &otel.Config{HTTP: &otel.HTTP{Routes: []*otel.Route{
+		{Pattern: "/users/{id}"},
+		{Method: "post", Pattern: "/files/{path...}"},
+		{Regex: `^/orders/\d+/items$`, Template: "/orders/{order}/items"},
+	}}})
+	srv := p.Middleware(okHandler())
+
+	cases := []struct {
+		name      string
+		method    string
+		target    string
+		wantName  string
+		wantRoute string
+	}{
+		{"pattern", http.MethodGet, "/users/42?token=secret", "GET /users/{id}", "/users/{id}"},
+		{"pattern does not match nested segments", http.MethodGet, "/users/42/friends", "GET", ""},
+		{"rest wildcard", http.MethodPost, "/files/a/b/c.txt", "POST /files/{path...}", "/files/{path...}"},
+		{"method mismatch", http.MethodGet, "/files/a.txt", "GET", ""},
+		{"regex", http.MethodPut, "/orders/7/items", "PUT /orders/{order}/items", "/orders/{order}/items"},
+		{"unknown method", "PURGE", "/users/42", "HTTP /users/{id}", "/users/{id}"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			srv.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(tc.method, tc.target, nil))
+
+			span := lastSpan(t, rec)
+			require.Equal(t, tc.wantName, span.Name())
+			route, ok := spanAttr(span, "http.route")
+			require.Equal(t, tc.wantRoute != "", ok)
+			require.Equal(t, tc.wantRoute, route.AsString())
+		})
+	}
+}
+
+// TestHTTP_RequestURISpanName verifies the span name stays the request URI when
+// no routes are configured.
+func TestHTTP_RequestURISpanName(t *testing.T) {
+	p, rec := newRecordedPlugin(t, &otel.Config{})
+	p.Middleware(okHandler()).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/users/42?x=1", nil))
+	require.Equal(t, "/users/42?x=1", lastSpan(t, rec).Name())
+}
+
+// TestHTTP_InvalidRoute verifies the invalid route definitions fail Plugin.Init.
+func TestHTTP_InvalidRoute(t *testing.T) {
+	for _, route := range []*otel.Route{{Pattern: "/users/{id"}, {Regex: "(", Template: "/x"}, {Regex: "^/x$"}} {
+		p := &otel.Plugin{}
+		err := p.Init(newConfigurer(&otel.Config{
+			Exporter: otel.Exporter("stdout"),
+			HTTP:     &otel.HTTP{Routes: []*otel.Route{route}},
+		}), mockLogger{})
+		require.Error(t, err)
+	}
+}
+
+// TestHTTP_WorkerHeaders verifies the X-RR-Otel-* response headers are applied
+// to the server span and are not sent to the client.
+func TestHTTP_WorkerHeaders(t *testing.T) {
+	p, rec := newRecordedPlugin(t, &otel.Config{HTTP: &otel.HTTP{WorkerHeaders: true}})
+
+	h := p.Middleware(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("X-RR-Otel-Route", "/users/{id}")
+		w.Header().Set("X-RR-Otel-Status", "error: payment declined")
+		w.Header().Set("X-RR-Otel-Attr-Tenant.Id", "acme")
+		w.Header().Add("X-RR-Otel-Attr-Features", "a")
+		w.Header().Add("X-RR-Otel-Attr-Features", "b")
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = w.Write([]byte("ok"))
+	}))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/users/42", nil))
+
+	for name := range w.Header() {
+		require.NotContains(t, strings.ToLower(name), "x-rr-otel-")
+	}
+	require.Equal(t, "text/plain", w.Header().Get("Content-Type"))
+
+	span := lastSpan(t, rec)
+	require.Equal(t, "GET /users/{id}", span.Name())
+	require.Equal(t, codes.Error, span.Status().Code)
+	require.Equal(t, "payment declined", span.Status().Description)
+
+	route, ok := spanAttr(span, "http.route")
+	require.True(t, ok)
+	require.Equal(t, "/users/{id}", route.AsString())
+
+	tenant, ok := spanAttr(span, "tenant.id")
+	require.True(t, ok)
+	require.Equal(t, "acme", tenant.AsString())
+
+	features, ok := spanAttr(span, "features")
+	require.True(t, ok)
+	require.Equal(t, []string{"a", "b"}, features.AsStringSlice())
+}
+
+// TestHTTP_WorkerHeadersEmptyResponse verifies the X-RR-Otel-* response headers are
+// applied and removed when the handler returns without writing the response.
+func TestHTTP_WorkerHeadersEmptyResponse(t *testing.T) {
+	p, rec := newRecordedPlugin(t, &otel.Config{HTTP: &otel.HTTP{WorkerHeaders: true}})
+
+	h := p.Middleware(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("X-RR-Otel-Route", "/users/{id}")
+	}))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/users/42", nil))
+
+	resp := w.Result()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Empty(t, resp.Header.Get("X-RR-Otel-Route"))
+	require.Equal(t, "GET /users/{id}", lastSpan(t, rec).Name())
+}
+
+// TestHTTP_WorkerHeadersDisabled verifies the X-RR-Otel-* response headers are
+// passed through untouched unless worker_headers is enabled.
+func TestHTTP_WorkerHeadersDisabled(t *testing.T) {
+	p, rec := newRecordedPlugin(t, &otel.Config{})
+
+	h := p.Middleware(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("X-RR-Otel-Route", "/users/{id}")
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/users/42", nil))
+
+	require.Equal(t, "/users/{id}", w.Header().Get("X-RR-Otel-Route"))
+	require.Equal(t, "/users/42", lastSpan(t, rec).Name())
+}
+
+// TestHTTP_Trust verifies only the trusted requests continue the incoming trace,
+// the untrusted ones start a new trace linked to the incoming span context.
+func TestHTTP_Trust(t *testing.T) {
+	const traceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+	cases := []struct {
+		name        string
+		remoteAddr  string
+		header      string
+		wantTrusted bool
+	}{
+		{"trusted cidr", "10.1.2.3:1234", "", true},
+		{"trusted ip", "192.168.0.7:1234", "", true},
+		{"trusted header", "203.0.113.1:1234", "s3cret", true},
+		{"wrong secret", "203.0.113.1:1234", "guess", false},
+		{"untrusted", "203.0.113.1:1234", "", false},
+	}
+
+	p, rec := newRecordedPlugin(t, &otel.Config{HTTP: &otel.HTTP{Trust: &otel.Trust{
+		CIDRs:  []string{"10.0.0.0/8", "192.168.0.7"},
+		Header: "X-Trace-Trusted",
+		Secret: "s3cret",
+	}}})
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var seen http.Header
+			h := p.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				seen = r.Header.Clone()
+				w.WriteHeader(http.StatusOK)
+			}))
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tc.remoteAddr
+			req.Header.Set("traceparent", traceparent)
+			req.Header.Set("baggage", "user=alice")
+			if tc.header != "" {
+				req.Header.Set("X-Trace-Trusted", tc.header)
+			}
+			h.ServeHTTP(httptest.NewRecorder(), req)
+
+			span := lastSpan(t, rec)
+			require.Empty(t, seen.Get("X-Trace-Trusted"))
+
+			if tc.wantTrusted {
+				require.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", span.SpanContext().TraceID().String())
+				require.Empty(t, span.Links())
+				require.Equal(t, "user=alice", seen.Get("baggage"))
+				return
+			}
+
+			require.NotEqual(t, "4bf92f3577b34da6a3ce929d0e0e4736", span.SpanContext().TraceID().String())
+			require.False(t, span.Parent().IsValid())
+			require.Len(t, span.Links(), 1)
+			require.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", span.Links()[0].SpanContext.TraceID().String())
+			require.Empty(t, seen.Get("baggage"))
+		})
+	}
+}
+
+// TestHTTP_TrustInvalid verifies an invalid trusted CIDR fails the plugin initialization.
+func TestHTTP_TrustInvalid(t *testing.T) {
+	p := &otel.Plugin{}
+	err := p.Init(newConfigurer(&otel.Config{
+		Exporter: otel.Exporter("stdout"),
+		HTTP:     &otel.HTTP{Trust: &otel.Trust{CIDRs: []string{"10.0.0.0/33"}}},
+	}), mockLogger{})
+	require.Error(t, err)
+}
+
+// TestHTTP_Exclude verifies the excluded requests are served without spans and
+// the incoming trace context reaches the worker unchanged.
+func TestHTTP_Exclude(t *testing.T) {
+	p, rec := newRecordedPlugin(t, &otel.Config{HTTP: &otel.HTTP{Exclude: &otel.Exclude{
+		Paths:      []string{"/health"},
+		Prefixes:   []string{"/static/"},
+		Globs:      []string{"/*.ico"},
+		Methods:    []string{"options"},
+		UserAgents: []string{"kube-probe"},
+	}}})
+
+	const traceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+	cases := []struct {
+		name      string
+		method    string
+		target    string
+		userAgent string
+		excluded  bool
+	}{
+		{"path", http.MethodGet, "/health", "", true},
+		{"path not exact", http.MethodGet, "/health/deep", "", false},
+		{"prefix", http.MethodGet, "/static/app.js", "", true},
+		{"glob", http.MethodGet, "/favicon.ico", "", true},
+		{"method", http.MethodOptions, "/users", "", true},
+		{"user agent", http.MethodGet, "/users", "Kube-Probe/1.29", true},
+		{"traced", http.MethodGet, "/users", "curl/8.0", false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var seen string
+			h := p.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				seen = r.Header.Get("traceparent")
+				w.WriteHeader(http.StatusOK)
+			}))
+
+			before := len(rec.Ended())
+			req := httptest.NewRequest(tc.method, tc.target, nil)
+			req.Header.Set("traceparent", traceparent)
+			req.Header.Set("User-Agent", tc.userAgent)
+			h.ServeHTTP(httptest.NewRecorder(), req)
+
+			if tc.excluded {
+				require.Len(t, rec.Ended(), before)
+				require.Equal(t, traceparent, seen)
+				return
+			}
+			require.Len(t, rec.Ended(), before+1)
+			require.NotEqual(t, traceparent, seen)
+		})
+	}
+}
+
+// TestHTTP_ExcludeTrust verifies the trust header and the untrusted baggage are
+// removed from the excluded requests as well.
+func TestHTTP_ExcludeTrust(t *testing.T) {
+	p, _ := newRecordedPlugin(t, &otel.Config{HTTP: &otel.HTTP{
+		Trust:   &otel.Trust{CIDRs: []string{"10.0.0.0/8"}, Header: "X-Trace-Trusted", Secret: "s3cret"},
+		Exclude: &otel.Exclude{Paths: []string{"/health"}},
+	}})
+
+	cases := []struct {
+		name        string
+		remoteAddr  string
+		header      string
+		wantBaggage string
+	}{
+		{"trusted header", "203.0.113.1:1234", "s3cret", "user=alice"},
+		{"wrong secret", "203.0.113.1:1234", "guess", ""},
+		{"trusted cidr", "10.1.2.3:1234", "", "user=alice"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var seen http.Header
+			h := p.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				seen = r.Header.Clone()
+				w.WriteHeader(http.StatusOK)
+			}))
+
+			req := httptest.NewRequest(http.MethodGet, "/health", nil)
+			req.RemoteAddr = tc.remoteAddr
+			req.Header.Set("baggage", "user=alice")
+			if tc.header != "" {
+				req.Header.Set("X-Trace-Trusted", tc.header)
+			}
+			h.ServeHTTP(httptest.NewRecorder(), req)
+
+			require.Empty(t, seen.Get("X-Trace-Trusted"))
+			require.Equal(t, tc.wantBaggage, seen.Get("baggage"))
+		})
+	}
+}
+
+// TestHTTP_ExcludeInvalid verifies an invalid exclude glob fails the plugin initialization.
+func TestHTTP_ExcludeInvalid(t *testing.T) {
+	p := &otel.Plugin{}
+	err := p.Init(newConfigurer(&otel.Config{
+		Exporter: otel.Exporter("stdout"),
+		HTTP:     &otel.HTTP{Exclude: &otel.Exclude{Globs: []string{"/["}}},
+	}), mockLogger{})
+	require.Error(t, err)
+}
+
+// TestHTTP_CaptureHeaders verifies the listed headers are recorded as span
+// attributes, truncated, and that the sensitive headers are never captured.
+func TestHTTP_CaptureHeaders(t *testing.T) {
+	p, rec := newRecordedPlugin(t, &otel.Config{HTTP: &otel.HTTP{CaptureHeaders: &otel.CaptureHeaders{
+		Request:   []string{"x-tenant-id", "User-Agent", "Authorization", "Cookie"},
+		Response:  []string{"Content-Type", "Set-Cookie"},
+		MaxLength: 8,
+	}}})
+
+	h := p.Middleware(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("Set-Cookie", "session=secret")
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Add("X-Tenant-ID", "acme")
+	req.Header.Add("X-Tenant-ID", "globex")
+	req.Header.Set("User-Agent", "Mozilla/5.0 (X11; Linux x86_64)")
+	req.Header.Set("Authorization", "Bearer secret")
+	req.Header.Set("Cookie", "session=secret")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	span := lastSpan(t, rec)
+
+	tenant, ok := spanAttr(span, "http.request.header.x-tenant-id")
+	require.True(t, ok)
+	require.Equal(t, []string{"acme", "globex"}, tenant.AsStringSlice())
+
+	ua, ok := spanAttr(span, "http.request.header.user-agent")
+	require.True(t, ok)
+	require.Equal(t, []string{"Mozilla/"}, ua.AsStringSlice())
+
+	ct, ok := spanAttr(span, "http.response.header.content-type")
+	require.True(t, ok)
+	require.Equal(t, []string{"text/pla"}, ct.AsStringSlice())
+
+	for _, key := range []attribute.Key{
+		"http.request.header.authorization",
+		"http.request.header.cookie",
+		"http.response.header.set-cookie",
+	} {
+		_, ok = spanAttr(span, key)
+		require.False(t, ok, key)
+	}
+}
+
+// TestHTTP_TraceResponse verifies the trace context is returned to the client
+// and the added headers are exposed to CORS requests.
+func TestHTTP_TraceResponse(t *testing.T) {
+	p, rec := newRecordedPlugin(t, &otel.Config{HTTP: &otel.HTTP{TraceResponse: &otel.TraceResponse{
+		TraceResponse: true,
+		ServerTiming:  true,
+		TraceIDHeader: "X-Trace-Id",
+	}}})
+
+	h := p.Middleware(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Access-Control-Expose-Headers", "X-Request-Id, x-trace-id")
+		w.Header().Set("Server-Timing", "db;dur=53")
+		_, _ = w.Write([]byte("ok"))
+	}))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	sc := lastSpan(t, rec).SpanContext()
+	value := "00-" + sc.TraceID().String() + "-" + sc.SpanID().String() + "-01"
+
+	require.Equal(t, value, w.Header().Get("traceresponse"))
+	require.Equal(t, []string{"db;dur=53", `traceparent;desc="` + value + `"`}, w.Header().Values("Server-Timing"))
+	require.Equal(t, sc.TraceID().String(), w.Header().Get("X-Trace-Id"))
+	require.Equal(t, []string{"X-Request-Id, x-trace-id", "traceresponse", "Server-Timing"}, w.Header().Values("Access-Control-Expose-Headers"))
+}
+
+// TestHTTP_TraceResponseEmptyResponse verifies the trace context headers are sent
+// when the handler returns without writing the response.
+func TestHTTP_TraceResponseEmptyResponse(t *testing.T) {
+	p, rec := newRecordedPlugin(t, &otel.Config{HTTP: &otel.HTTP{TraceResponse: &otel.TraceResponse{
+		TraceResponse: true,
+		ServerTiming:  true,
+		TraceIDHeader: "X-Trace-Id",
+	}}})
+
+	h := p.Middleware(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	sc := lastSpan(t, rec).SpanContext()
+	value := "00-" + sc.TraceID().String() + "-" + sc.SpanID().String() + "-01"
+
+	resp := w.Result()
+	require.Equal(t, value, resp.Header.Get("traceresponse"))
+	require.Equal(t, `traceparent;desc="`+value+`"`, resp.Header.Get("Server-Timing"))
+	require.Equal(t, sc.TraceID().String(), resp.Header.Get("X-Trace-Id"))
+}
+
+// TestHTTP_Redact verifies the query parameters and the masked patterns are
+// redacted in the span name and the URL attributes.
+func TestHTTP_Redact(t *testing.T) {
+	cases := []struct {
+		name      string
+		redact    *otel.Redact
+		wantName  string
+		wantPath  string
+		wantQuery string
+	}{
+		{
+			name:      "deny",
+			redact:    &otel.Redact{Deny: []string{"Token", "sig"}},
+			wantName:  "/users/bob@example.com?page=2&token=REDACTED&sig=REDACTED&flag",
+			wantPath:  "/users/bob@example.com",
+			wantQuery: "page=2&token=REDACTED&sig=REDACTED&flag",
+		},
+		{
+			name:      "allow and patterns",
+			redact:    &otel.Redact{Allow: []string{"page"}, Patterns: []string{`[^/@]+@[^/&]+`}},
+			wantName:  "/users/REDACTED?page=2&token=REDACTED&sig=REDACTED&flag",
+			wantPath:  "/users/REDACTED",
+			wantQuery: "page=2&token=REDACTED&sig=REDACTED&flag",
+		},
+		{
+			name:     "drop query",
+			redact:   &otel.Redact{DropQuery: true},
+			wantName: "/users/bob@example.com",
+			wantPath: "/users/bob@example.com",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			p, rec := newRecordedPlugin(t, &otel.Config{HTTP: &otel.HTTP{Redact: tc.redact}})
+
+			req := httptest.NewRequest(http.MethodGet, "/users/bob@example.com?page=2&token=abc&sig=xyz&flag", nil)
+			p.Middleware(okHandler()).ServeHTTP(httptest.NewRecorder(), req)
+
+			span := lastSpan(t, rec)
+			require.Equal(t, tc.wantName, span.Name())
+
+			path, ok := spanAttr(span, "url.path")
+			require.True(t, ok)
+			require.Equal(t, tc.wantPath, path.AsString())
+
+			query, ok := spanAttr(span, "url.query")
+			require.Equal(t, tc.wantQuery != "", ok)
+			require.Equal(t, tc.wantQuery, query.AsString())
+		})
+	}
+}
+
+// TestHTTP_TrustedProxies verifies client.address and url.scheme come from the
+// forwarding headers only when the peer is a trusted proxy.
+func TestHTTP_TrustedProxies(t *testing.T) {
+	p, rec := newRecordedPlugin(t, &otel.Config{HTTP: &otel.HTTP{TrustedProxies: []string{"10.0.0.0/8"}}})
+
+	cases := []struct {
+		name       string
+		remoteAddr string
+		headers    map[string]string
+		wantClient string
+		wantScheme string
+	}{
+		{"untrusted peer", "203.0.113.9:4000", map[string]string{"X-Forwarded-For": "198.51.100.1", "X-Forwarded-Proto": "https"}, "203.0.113.9", "http"},
+		{"x-forwarded-for chain", "10.0.0.1:4000", map[string]string{"X-Forwarded-For": "198.51.100.1, 192.0.2.7, 10.0.0.2", "X-Forwarded-Proto": "https"}, "192.0.2.7", "https"},
+		{"all trusted", "10.0.0.1:4000", map[string]string{"X-Forwarded-For": "10.1.1.1, 10.0.0.2"}, "10.1.1.1", "http"},
+		{"forwarded", "10.0.0.1:4000", map[string]string{"Forwarded": `for="[2001:db8::17]:4711";proto=https, for=10.0.0.2;proto=http`}, "2001:db8::17", "https"},
+		{"x-real-ip", "10.0.0.1:4000", map[string]string{"X-Real-IP": "198.51.100.3"}, "198.51.100.3", "http"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tc.remoteAddr
+			for name, value := range tc.headers {
+				req.Header.Set(name, value)
+			}
+			p.Middleware(okHandler()).ServeHTTP(httptest.NewRecorder(), req)
+
+			span := lastSpan(t, rec)
+
+			addr, ok := spanAttr(span, "client.address")
+			require.True(t, ok)
+			require.Equal(t, tc.wantClient, addr.AsString())
+
+			sch, ok := spanAttr(span, "url.scheme")
+			require.True(t, ok)
+			require.Equal(t, tc.wantScheme, sch.AsString())
+
+			peer, ok := spanAttr(span, "network.peer.address")
+			require.True(t, ok)
+			require.Equal(t, tc.remoteAddr[:strings.LastIndexByte(tc.remoteAddr, ':')], peer.AsString())
+		})
+	}
+}
+
+// TestHTTP_MessageEvents verifies the per-read/write events can be disabled or
+// replaced with the aggregated attributes.
+func TestHTTP_MessageEvents(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		for range 3 {
+			_, _ = w.Write(make([]byte, 100))
+		}
+	})
+
+	cases := []struct {
+		mode          otel.MessageEvents
+		wantEvents    bool
+		wantAggregate bool
+	}{
+		{"", true, false},
+		{"all", true, false},
+		{"none", false, false},
+		{"aggregate", false, true},
+	}
+
+	for _, tc := range cases {
+		t.Run(string(tc.mode), func(t *testing.T) {
+			p, rec := newRecordedPlugin(t, &otel.Config{HTTP: &otel.HTTP{MessageEvents: tc.mode}})
+
+			req := httptest.NewRequest(http.MethodPost, "/upload", strings.NewReader(strings.Repeat("x", 1000)))
+			p.Middleware(handler).ServeHTTP(httptest.NewRecorder(), req)
+
+			span := lastSpan(t, rec)
+			require.Equal(t, tc.wantEvents, len(span.Events()) > 0)
+
+			read, ok := spanAttr(span, "http.read_bytes")
+			require.Equal(t, tc.wantAggregate, ok)
+			if !tc.wantAggregate {
+				return
+			}
+			require.Equal(t, int64(1000), read.AsInt64())
+
+			wrote, ok := spanAttr(span, "http.wrote_bytes")
+			require.True(t, ok)
+			require.Equal(t, int64(300), wrote.AsInt64())
+
+			for _, key := range []attribute.Key{"http.read_duration", "http.write_duration", "http.time_to_first_byte"} {
+				_, ok = spanAttr(span, key)
+				require.True(t, ok, key)
+			}
+		})
+	}
+}
+
+// TestHTTP_Recovery verifies a handler panic is recorded on the span and either
+// answered with 500 or propagated after the span is flushed.
+func TestHTTP_Recovery(t *testing.T) {
+	panicking := http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		panic("boom")
+	})
+
+	assertPanic := func(t *testing.T, rec *tracetest.SpanRecorder) {
+		t.Helper()
+
+		span := lastSpan(t, rec)
+		// the description of the 500 response status set by otelhttp is empty
+		require.Equal(t, codes.Error, span.Status().Code)
+
+		idx := slices.IndexFunc(span.Events(), func(e sdktrace.Event) bool { return e.Name == "exception" })
+		require.GreaterOrEqual(t, idx, 0)
+
+		attrs := attribute.NewSet(span.Events()[idx].Attributes...)
+		typ, _ := attrs.Value("exception.type")
+		require.Equal(t, "string", typ.AsString())
+		msg, _ := attrs.Value("exception.message")
+		require.Equal(t, "boom", msg.AsString())
+		stack, _ := attrs.Value("exception.stacktrace")
+		require.Contains(t, stack.AsString(), "TestHTTP_Recovery")
+	}
+
+	t.Run("respond", func(t *testing.T) {
+		p, rec := newRecordedPlugin(t, &otel.Config{HTTP: &otel.HTTP{Recovery: &otel.Recovery{}}})
+
+		w := httptest.NewRecorder()
+		p.Middleware(panicking).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+		require.Equal(t, http.StatusInternalServerError, w.Code)
+		assertPanic(t, rec)
+	})
+
+	t.Run("repanic", func(t *testing.T) {
+		p, rec := newRecordedPlugin(t, &otel.Config{HTTP: &otel.HTTP{Recovery: &otel.Recovery{Repanic: true}}})
+
+		require.PanicsWithValue(t, "boom", func() {
+			p.Middleware(panicking).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+		})
+		assertPanic(t, rec)
+		require.Equal(t, "boom", lastSpan(t, rec).Status().Description)
+	})
+
+	t.Run("repanic with tail sampling", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "traces.jsonl")
+		p := &otel.Plugin{}
+		require.NoError(t, p.Init(newConfigurer(&otel.Config{
+			Exporter:     otel.Exporter("file"),
+			File:         &otel.File{Path: path},
+			Sampler:      &otel.Sampler{Type: "always_on"},
+			TailSampling: &otel.TailSampling{DecisionWait: time.Minute, Ratio: 1},
+			HTTP:         &otel.HTTP{Recovery: &otel.Recovery{Repanic: true}},
+		}), mockLogger{}))
+		t.Cleanup(func() { _ = p.Stop(context.Background()) })
+
+		_, pending := p.Tracer().Tracer("test").Start(context.Background(), "pending")
+		pending.End()
+
+		require.Panics(t, func() {
+			p.Middleware(panicking).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+		})
+
+		// the buffered traces are not decided before their decision window is over
+		data, err := os.ReadFile(path)
+		if err == nil {
+			require.NotContains(t, string(data), "pending")
+		}
+	})
+}
@@ -0,0 +1,113 @@
+package tests
+
+import (
+	"context"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/roadrunner-server/otel/v6"
+	"github.com/stretchr/testify/require"
+	collogspb "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// TestLogs_Handler verifies the slog handler is only provided when the logs
+// section is configured, and that records logged inside a span are accepted and
+// flushed on Stop.
+func TestLogs_Handler(t *testing.T) {
+	disabled := &otel.Plugin{}
+	require.NoError(t, disabled.Init(newConfigurer(&otel.Config{Exporter: otel.Exporter("stdout")}), mockLogger{}))
+	require.Nil(t, disabled.LoggerProvider())
+	require.Nil(t, disabled.LogHandler("test"))
+	require.NoError(t, disabled.Stop(context.Background()))
+
+	p := &otel.Plugin{}
+	require.NoError(t, p.Init(newConfigurer(&otel.Config{
+		Exporter: otel.Exporter("stdout"),
+		Logs:     &otel.Logs{SignalExporter: otel.SignalExporter{Exporter: otel.Exporter("stderr")}},
+	}), mockLogger{}))
+	require.NotNil(t, p.LoggerProvider())
+
+	handler := p.LogHandler("test")
+	require.NotNil(t, handler)
+	require.True(t, handler.Enabled(context.Background(), slog.LevelInfo))
+
+	ctx, span := p.Tracer().Tracer("test").Start(context.Background(), "span")
+	slog.New(handler).InfoContext(ctx, "inside the span", "key", "value")
+	span.End()
+
+	require.NoError(t, p.Stop(context.Background()))
+}
+
+// TestLogs_TraceContext verifies the records logged through the slog handler carry
+// the trace_id and span_id of the span in the context passed to the logger.
+func TestLogs_TraceContext(t *testing.T) {
+	var mu sync.Mutex
+	var records []*logspb.LogRecord
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+
+		req := &collogspb.ExportLogsServiceRequest{}
+		require.NoError(t, proto.Unmarshal(body, req))
+
+		mu.Lock()
+		for _, rl := range req.GetResourceLogs() {
+			for _, sl := range rl.GetScopeLogs() {
+				records = append(records, sl.GetLogRecords()...)
+			}
+		}
+		mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/x-protobuf")
+		out, _ := proto.Marshal(&collogspb.ExportLogsServiceResponse{})
+		_, _ = w.Write(out)
+	}))
+	t.Cleanup(collector.Close)
+
+	p := &otel.Plugin{}
+	require.NoError(t, p.Init(newConfigurer(&otel.Config{
+		Exporter: otel.Exporter("stdout"),
+		Sampler:  &otel.Sampler{Type: "always_on"},
+		Logs: &otel.Logs{SignalExporter: otel.SignalExporter{
+			Exporter: otel.Exporter("otlp"),
+			Client:   otel.Client("http"),
+			Endpoint: strings.TrimPrefix(collector.URL, "http://"),
+			Insecure: ptr(true),
+		}},
+	}), mockLogger{}))
+
+	ctx, span := p.Tracer().Tracer("test").Start(context.Background(), "span")
+	logger := slog.New(p.LogHandler("test"))
+	logger.InfoContext(ctx, "inside the span")
+	logger.Info("outside the span")
+	span.End()
+
+	// the records are exported on Stop
+	require.NoError(t, p.Stop(context.Background()))
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Len(t, records, 2)
+
+	sc := span.SpanContext()
+	for _, rec := range records {
+		switch rec.GetBody().GetStringValue() {
+		case "inside the span":
+			require.Equal(t, sc.TraceID().String(), hex.EncodeToString(rec.GetTraceId()))
+			require.Equal(t, sc.SpanID().String(), hex.EncodeToString(rec.GetSpanId()))
+		case "outside the span":
+			require.Empty(t, rec.GetTraceId())
+			require.Empty(t, rec.GetSpanId())
+		default:
+			t.Fatalf("unexpected record %q", rec.GetBody().GetStringValue())
+		}
+	}
+}
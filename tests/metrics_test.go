@@ -0,0 +1,174 @@
+package tests
+
+import (
+	"context"
+	"io"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/roadrunner-server/otel/v6"
+	"github.com/stretchr/testify/require"
+	colmetricpb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricpb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// TestMetrics_MeterProvider verifies the meter provider is only built when the
+// metrics section is configured, and that Stop flushes and shuts it down.
+func TestMetrics_MeterProvider(t *testing.T) {
+	disabled := &otel.Plugin{}
+	require.NoError(t, disabled.Init(newConfigurer(&otel.Config{Exporter: otel.Exporter("stdout")}), mockLogger{}))
+	require.Nil(t, disabled.MeterProvider())
+	require.NoError(t, disabled.Stop(context.Background()))
+
+	p := &otel.Plugin{}
+	require.NoError(t, p.Init(newConfigurer(&otel.Config{
+		Exporter: otel.Exporter("stdout"),
+		Metrics:  &otel.Metrics{SignalExporter: otel.SignalExporter{Exporter: otel.Exporter("stderr")}},
+	}), mockLogger{}))
+	require.NotNil(t, p.MeterProvider())
+
+	counter, err := p.MeterProvider().Meter("test").Int64Counter("requests")
+	require.NoError(t, err)
+	counter.Add(context.Background(), 1)
+
+	require.NoError(t, p.Stop(context.Background()))
+}
+
+// TestMetrics_UnsupportedExporter verifies the trace-only exporters are rejected
+// for the metrics pipeline.
+func TestMetrics_UnsupportedExporter(t *testing.T) {
+	p := &otel.Plugin{}
+	err := p.Init(newConfigurer(&otel.Config{
+		Exporter: otel.Exporter("stdout"),
+		Metrics:  &otel.Metrics{SignalExporter: otel.SignalExporter{Exporter: otel.Exporter("zipkin")}},
+	}), mockLogger{})
+	require.Error(t, err)
+}
+
+// collectMetrics starts a stand-in OTLP/HTTP collector and returns its endpoint and
+// a function returning the metrics received so far by name.
+func collectMetrics(t *testing.T) (string, func() map[string]*metricpb.Metric) {
+	var mu sync.Mutex
+	metrics := make(map[string]*metricpb.Metric)
+
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+
+		req := &colmetricpb.ExportMetricsServiceRequest{}
+		require.NoError(t, proto.Unmarshal(body, req))
+
+		mu.Lock()
+		for _, rm := range req.GetResourceMetrics() {
+			for _, sm := range rm.GetScopeMetrics() {
+				for _, m := range sm.GetMetrics() {
+					metrics[m.GetName()] = m
+				}
+			}
+		}
+		mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/x-protobuf")
+		out, _ := proto.Marshal(&colmetricpb.ExportMetricsServiceResponse{})
+		_, _ = w.Write(out)
+	}))
+	t.Cleanup(collector.Close)
+
+	return strings.TrimPrefix(collector.URL, "http://"), func() map[string]*metricpb.Metric {
+		mu.Lock()
+		defer mu.Unlock()
+		return maps.Clone(metrics)
+	}
+}
+
+func pointAttrs(attrs []*commonpb.KeyValue) map[string]string {
+	res := make(map[string]string, len(attrs))
+	for _, attr := range attrs {
+		switch v := attr.GetValue().GetValue().(type) {
+		case *commonpb.AnyValue_StringValue:
+			res[attr.GetKey()] = v.StringValue
+		case *commonpb.AnyValue_IntValue:
+			res[attr.GetKey()] = strconv.FormatInt(v.IntValue, 10)
+		}
+	}
+	return res
+}
+
+// TestMetrics_HTTPMiddleware serves requests through the middleware and checks the
+// exported HTTP server metrics: their attributes and the custom histogram boundaries.
+func TestMetrics_HTTPMiddleware(t *testing.T) {
+	endpoint, received := collectMetrics(t)
+
+	p := &otel.Plugin{}
+	cfg := &otel.Config{
+		Exporter: otel.Exporter("stdout"),
+		Metrics: &otel.Metrics{
+			SignalExporter:      otel.SignalExporter{Exporter: otel.Exporter("otlp"), Client: otel.Client("http"), Endpoint: endpoint, Insecure: ptr(true)},
+			HTTPDurationBuckets: []float64{1, 0.1, 0.5},
+			HTTPBodySizeBuckets: []float64{1024, 4096},
+		},
+		HTTP: &otel.HTTP{Routes: []*otel.Route{{Pattern: "/users/{id}"}}},
+	}
+	require.NoError(t, p.Init(newConfigurer(cfg), mockLogger{}))
+	require.Equal(t, []float64{0.1, 0.5, 1}, cfg.Metrics.HTTPDurationBuckets, "boundaries must be sorted")
+
+	srv := p.Middleware(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		_, _ = io.WriteString(w, "ok")
+	}))
+	for _, method := range []string{http.MethodGet, "PURGE"} {
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, httptest.NewRequest(method, "/users/42", strings.NewReader("body")))
+		require.Equal(t, http.StatusCreated, rec.Code)
+	}
+
+	// the metrics are exported on Stop
+	require.NoError(t, p.Stop(context.Background()))
+	metrics := received()
+
+	duration, ok := metrics["http.server.request.duration"]
+	require.True(t, ok, "the request duration must be recorded")
+	points := duration.GetHistogram().GetDataPoints()
+	require.Len(t, points, 2)
+	var methods []string
+	for _, dp := range points {
+		require.Equal(t, []float64{0.1, 0.5, 1}, dp.GetExplicitBounds())
+		require.Equal(t, uint64(1), dp.GetCount())
+
+		attrs := pointAttrs(dp.GetAttributes())
+		require.Equal(t, "201", attrs["http.response.status_code"])
+		require.Equal(t, "/users/{id}", attrs["http.route"])
+		require.Equal(t, "http", attrs["url.scheme"])
+		methods = append(methods, attrs["http.request.method"])
+	}
+	require.ElementsMatch(t, []string{"GET", "_OTHER"}, methods)
+
+	for _, name := range []string{"http.server.request.body.size", "http.server.response.body.size"} {
+		size, ok := metrics[name]
+		require.True(t, ok, "%s must be recorded", name)
+		require.NotEmpty(t, size.GetHistogram().GetDataPoints())
+		for _, dp := range size.GetHistogram().GetDataPoints() {
+			require.Equal(t, []float64{1024, 4096}, dp.GetExplicitBounds())
+		}
+	}
+
+	active, ok := metrics["http.server.active_requests"]
+	require.True(t, ok, "the active requests must be recorded")
+	activePoints := active.GetSum().GetDataPoints()
+	require.Len(t, activePoints, 2)
+	methods = methods[:0]
+	for _, dp := range activePoints {
+		require.Zero(t, dp.GetAsInt(), "all the requests are finished")
+		attrs := pointAttrs(dp.GetAttributes())
+		require.Equal(t, "http", attrs["url.scheme"])
+		methods = append(methods, attrs["http.request.method"])
+	}
+	require.ElementsMatch(t, []string{"GET", "_OTHER"}, methods)
+}
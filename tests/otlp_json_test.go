@@ -0,0 +1,111 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/roadrunner-server/otel/v6"
+	"github.com/stretchr/testify/require"
+	otelapi "go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TestOTLPJSON_Export verifies the http/json client sends the spans in the OTLP
+// JSON mapping: hex trace and span IDs, integer enums and the JSON content type.
+func TestOTLPJSON_Export(t *testing.T) {
+	var mu sync.Mutex
+	var bodies [][]byte
+	var headers []http.Header
+
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+
+		mu.Lock()
+		bodies = append(bodies, body)
+		headers = append(headers, r.Header.Clone())
+		mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte("{}"))
+	}))
+	t.Cleanup(collector.Close)
+
+	p := &otel.Plugin{}
+	require.NoError(t, p.Init(newConfigurer(&otel.Config{
+		Exporter:  otel.Exporter("otlp"),
+		Client:    otel.Client("http/json"),
+		Endpoint:  strings.TrimPrefix(collector.URL, "http://"),
+		CustomURL: "/ingest/traces",
+		Insecure:  true,
+		Headers:   map[string]string{"X-Api-Key": "key"},
+		Sampler:   &otel.Sampler{Type: "always_on"},
+	}), mockLogger{}))
+
+	_, span := otelapi.Tracer("test").Start(context.Background(), "json-span", trace.WithSpanKind(trace.SpanKindServer))
+	sc := span.SpanContext()
+	span.End()
+
+	require.NoError(t, p.Stop(context.Background()))
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.NotEmpty(t, bodies)
+	require.Equal(t, "application/json", headers[0].Get("Content-Type"))
+	require.Equal(t, "key", headers[0].Get("X-Api-Key"))
+
+	var req struct {
+		ResourceSpans []struct {
+			ScopeSpans []struct {
+				Spans []struct {
+					TraceID string `json:"traceId"`
+					SpanID  string `json:"spanId"`
+					Name    string `json:"name"`
+					Kind    int    `json:"kind"`
+				} `json:"spans"`
+			} `json:"scopeSpans"`
+		} `json:"resourceSpans"`
+	}
+	require.NoError(t, json.Unmarshal(bodies[0], &req))
+	require.NotEmpty(t, req.ResourceSpans)
+	require.NotEmpty(t, req.ResourceSpans[0].ScopeSpans)
+
+	got := req.ResourceSpans[0].ScopeSpans[0].Spans[0]
+	require.Equal(t, "json-span", got.Name)
+	require.Equal(t, sc.TraceID().String(), got.TraceID)
+	require.Equal(t, sc.SpanID().String(), got.SpanID)
+	// SPAN_KIND_SERVER
+	require.Equal(t, 2, got.Kind)
+}
+
+// TestOTLPJSON_ExportError verifies a non-2xx collector response is reported
+// as an export failure without breaking the plugin shutdown.
+func TestOTLPJSON_ExportError(t *testing.T) {
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		http.Error(w, "bad request", http.StatusBadRequest)
+	}))
+	t.Cleanup(collector.Close)
+
+	p := &otel.Plugin{}
+	require.NoError(t, p.Init(newConfigurer(&otel.Config{
+		Exporter: otel.Exporter("otlp"),
+		Client:   otel.Client("http/json"),
+		Endpoint: strings.TrimPrefix(collector.URL, "http://"),
+		Insecure: true,
+		Sampler:  &otel.Sampler{Type: "always_on"},
+	}), mockLogger{}))
+
+	_, span := otelapi.Tracer("test").Start(context.Background(), "json-span")
+	span.End()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.Error(t, p.Stop(ctx))
+}
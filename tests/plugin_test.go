@@ -105,3 +105,29 @@ func TestPlugin_LifecycleAndMiddleware(t *testing.T) {
 
 	require.NoError(t, p.Stop(context.Background()))
 }
+
+// TestPlugin_Sampler verifies the configured sampler is installed on the tracer
+// provider built by Plugin.Init.
+func TestPlugin_Sampler(t *testing.T) {
+	cases := []struct {
+		name        string
+		sampler     *otel.Sampler
+		wantSampled bool
+	}{
+		{"always_on", &otel.Sampler{Type: "always_on"}, true},
+		{"always_off", &otel.Sampler{Type: "always_off"}, false},
+		{"zero ratio", &otel.Sampler{Type: "traceidratio", Ratio: ptr(0.0)}, false},
+		{"full ratio", &otel.Sampler{Type: "parentbased_traceidratio", Ratio: ptr(1.0)}, true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			p := &otel.Plugin{}
+			require.NoError(t, p.Init(newConfigurer(&otel.Config{Exporter: otel.Exporter("stdout"), Sampler: tc.sampler}), mockLogger{}))
+			t.Cleanup(func() { _ = p.Stop(context.Background()) })
+
+			_, span := p.Tracer().Tracer("test").Start(context.Background(), "span")
+			require.Equal(t, tc.wantSampled, span.SpanContext().IsSampled())
+		})
+	}
+}
@@ -0,0 +1,132 @@
+package tests
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/roadrunner-server/otel/v6"
+	"github.com/stretchr/testify/require"
+	otelapi "go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	testTraceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	testSpanID  = "00f067aa0ba902b7"
+)
+
+// TestPropagation_Configured verifies the incoming context is extracted only
+// with the configured propagators, and that they are registered globally.
+func TestPropagation_Configured(t *testing.T) {
+	cases := []struct {
+		name        string
+		propagators []string
+		header      string
+		value       string
+		wantJoined  bool
+	}{
+		{"b3 single", []string{"b3"}, "b3", testTraceID + "-" + testSpanID + "-1", true},
+		{"b3 multi", []string{"b3multi"}, "X-B3-TraceId", testTraceID, true},
+		{"ottrace", []string{"ottrace"}, "ot-tracer-traceid", testTraceID[16:], false},
+		{"tracecontext not configured", []string{"b3"}, "traceparent", "00-" + testTraceID + "-" + testSpanID + "-01", false},
+		{"none", []string{"none"}, "traceparent", "00-" + testTraceID + "-" + testSpanID + "-01", false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			p := &otel.Plugin{}
+			require.NoError(t, p.Init(newConfigurer(&otel.Config{
+				Exporter:    otel.Exporter("stdout"),
+				Sampler:     &otel.Sampler{Type: "always_off"},
+				Propagators: tc.propagators,
+			}), mockLogger{}))
+			t.Cleanup(func() { _ = p.Stop(context.Background()) })
+
+			var got trace.TraceID
+			srv := p.Middleware(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+				got = trace.SpanContextFromContext(r.Context()).TraceID()
+			}))
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set(tc.header, tc.value)
+			if tc.header == "X-B3-TraceId" {
+				req.Header.Set("X-B3-SpanId", testSpanID)
+				req.Header.Set("X-B3-Sampled", "1")
+			}
+			srv.ServeHTTP(httptest.NewRecorder(), req)
+
+			require.Equal(t, tc.wantJoined, got.String() == testTraceID, got.String())
+
+			// the plugin propagators are the global ones
+			global := otelapi.GetTextMapPropagator().Extract(context.Background(), propagation.HeaderCarrier(req.Header))
+			require.Equal(t, tc.wantJoined, trace.SpanContextFromContext(global).TraceID().String() == testTraceID)
+		})
+	}
+}
+
+// TestPropagation_Unknown verifies an unknown propagator fails Plugin.Init.
+func TestPropagation_Unknown(t *testing.T) {
+	p := &otel.Plugin{}
+	err := p.Init(newConfigurer(&otel.Config{
+		Exporter:    otel.Exporter("stdout"),
+		Propagators: []string{"tracecontext", "bogus"},
+	}), mockLogger{})
+	require.Error(t, err)
+}
+
+// TestPropagation_WorkerParent verifies the worker receives the RoadRunner server
+// span, not the upstream one, as the parent in the request headers.
+func TestPropagation_WorkerParent(t *testing.T) {
+	p, rec := newRecordedPlugin(t, &otel.Config{})
+
+	var seen http.Header
+	h := p.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = r.Header.Clone()
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("traceparent", "00-"+testTraceID+"-"+testSpanID+"-01")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	span := lastSpan(t, rec)
+	require.Equal(t, testTraceID, span.SpanContext().TraceID().String())
+	require.Equal(t, testSpanID, span.Parent().SpanID().String())
+
+	require.Equal(t, "00-"+testTraceID+"-"+span.SpanContext().SpanID().String()+"-01", seen.Get("traceparent"))
+	require.Contains(t, seen.Get("uber-trace-id"), span.SpanContext().SpanID().String())
+}
+
+// TestPropagation_WorkerInject verifies only the configured formats are injected
+// toward the worker and the upstream headers are not passed through.
+func TestPropagation_WorkerInject(t *testing.T) {
+	p, rec := newRecordedPlugin(t, &otel.Config{HTTP: &otel.HTTP{Inject: []string{"b3"}}})
+
+	var seen http.Header
+	h := p.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = r.Header.Clone()
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("traceparent", "00-"+testTraceID+"-"+testSpanID+"-01")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	sc := lastSpan(t, rec).SpanContext()
+	require.Empty(t, seen.Get("traceparent"))
+	require.Empty(t, seen.Get("uber-trace-id"))
+	require.Equal(t, sc.TraceID().String()+"-"+sc.SpanID().String()+"-1", seen.Get("b3"))
+}
+
+// TestPropagation_WorkerInjectUnknown verifies an unknown inject propagator fails the plugin initialization.
+func TestPropagation_WorkerInjectUnknown(t *testing.T) {
+	p := &otel.Plugin{}
+	err := p.Init(newConfigurer(&otel.Config{
+		Exporter: otel.Exporter("stdout"),
+		HTTP:     &otel.HTTP{Inject: []string{"unknown"}},
+	}), mockLogger{})
+	require.Error(t, err)
+}
@@ -0,0 +1,97 @@
+package tests
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/roadrunner-server/otel/v6"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// TestRouting_ByAttribute verifies the spans are dispatched by the attribute
+// value, the children follow their parent route and the unmatched spans are
+// sent to the exporters.
+func TestRouting_ByAttribute(t *testing.T) {
+	dir := t.TempDir()
+	acme, globex, rest := filepath.Join(dir, "acme.jsonl"), filepath.Join(dir, "globex.jsonl"), filepath.Join(dir, "rest.jsonl")
+
+	route := func(path string, values ...string) *otel.SpanRoute {
+		return &otel.SpanRoute{
+			SpanExporter: otel.SpanExporter{SignalExporter: otel.SignalExporter{Exporter: "file"}, File: &otel.File{Path: path}},
+			Values:       values,
+		}
+	}
+
+	p := &otel.Plugin{}
+	require.NoError(t, p.Init(newConfigurer(&otel.Config{
+		Sampler:   &otel.Sampler{Type: "always_on"},
+		Exporters: []*otel.SpanExporter{{SignalExporter: otel.SignalExporter{Exporter: "file"}, File: &otel.File{Path: rest}}},
+		Routing: &otel.Routing{
+			Attribute: "tenant.id",
+			Routes:    []*otel.SpanRoute{route(acme, "acme"), route(globex, "globex", "initech")},
+		},
+	}), mockLogger{}))
+
+	tracer := p.Tracer().Tracer("test")
+	ctx := context.Background()
+
+	parentCtx, parent := tracer.Start(ctx, "acme-parent")
+	parent.SetAttributes(attribute.String("tenant.id", "acme"))
+	_, child := tracer.Start(parentCtx, "acme-child")
+	child.End()
+	parent.End()
+
+	_, slice := tracer.Start(ctx, "initech-span")
+	slice.SetAttributes(attribute.StringSlice("tenant.id", []string{"unknown", "initech"}))
+	slice.End()
+
+	_, other := tracer.Start(ctx, "other-span")
+	other.SetAttributes(attribute.String("tenant.id", "unknown"))
+	other.End()
+
+	require.NoError(t, p.Stop(ctx))
+
+	spanNames := func(path string) []string {
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+
+		var names []string
+		for _, name := range []string{"acme-parent", "acme-child", "initech-span", "other-span"} {
+			if strings.Contains(string(data), `"name":"`+name+`"`) {
+				names = append(names, name)
+			}
+		}
+		return names
+	}
+
+	require.Equal(t, []string{"acme-parent", "acme-child"}, spanNames(acme))
+	require.Equal(t, []string{"initech-span"}, spanNames(globex))
+	require.Equal(t, []string{"other-span"}, spanNames(rest))
+}
+
+// TestRouting_Invalid verifies the routing without the attribute or the route
+// values fails the plugin initialization.
+func TestRouting_Invalid(t *testing.T) {
+	cases := []struct {
+		name    string
+		routing *otel.Routing
+	}{
+		{"no attribute", &otel.Routing{Routes: []*otel.SpanRoute{{Values: []string{"acme"}}}}},
+		{"no values", &otel.Routing{Attribute: "tenant.id", Routes: []*otel.SpanRoute{{}}}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			p := &otel.Plugin{}
+			err := p.Init(newConfigurer(&otel.Config{
+				Exporter: otel.Exporter("stdout"),
+				Routing:  tc.routing,
+			}), mockLogger{})
+			require.Error(t, err)
+		})
+	}
+}
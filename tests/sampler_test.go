@@ -0,0 +1,359 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/roadrunner-server/otel/v6"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TestSampler_Rules drives requests through the plugin middleware and checks the
+// sampling decision of the server span for every sampling rule branch.
+func TestSampler_Rules(t *testing.T) {
+	p := &otel.Plugin{}
+	require.NoError(t, p.Init(newConfigurer(&otel.Config{
+		Exporter: otel.Exporter("stdout"),
+		Sampler: &otel.Sampler{
+			Type: "always_off",
+			Rules: []*otel.SamplingRule{
+				{PathPrefix: "/health", Ratio: 0},
+				{Method: "post", PathRegex: `^/checkout(/.*)?$`, Ratio: 1},
+				{Host: "API.example.com", Ratio: 1},
+			},
+		},
+	}), mockLogger{}))
+	t.Cleanup(func() { _ = p.Stop(context.Background()) })
+
+	var sampled bool
+	srv := p.Middleware(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		sampled = trace.SpanContextFromContext(r.Context()).IsSampled()
+	}))
+
+	cases := []struct {
+		name   string
+		method string
+		target string
+		want   bool
+	}{
+		{"prefix rule drops", http.MethodGet, "http://api.example.com/health/live", false},
+		{"method and regex rule keeps", http.MethodPost, "http://localhost/checkout/pay", true},
+		{"method mismatch falls through", http.MethodGet, "http://localhost/checkout/pay", false},
+		{"host rule keeps", http.MethodGet, "http://api.example.com:8080/users", true},
+		{"fallback sampler", http.MethodGet, "http://localhost/users", false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			sampled = !tc.want
+			srv.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(tc.method, tc.target, nil))
+			require.Equal(t, tc.want, sampled)
+		})
+	}
+}
+
+// TestSampler_RulesClientSpan verifies the sampling rules apply to the server spans
+// only, the HTTP client spans are delegated to the fallback sampler.
+func TestSampler_RulesClientSpan(t *testing.T) {
+	p := &otel.Plugin{}
+	require.NoError(t, p.Init(newConfigurer(&otel.Config{
+		Exporter: otel.Exporter("stdout"),
+		Sampler: &otel.Sampler{
+			Type:  "always_on",
+			Rules: []*otel.SamplingRule{{Ratio: 0}},
+		},
+	}), mockLogger{}))
+	t.Cleanup(func() { _ = p.Stop(context.Background()) })
+
+	attrs := trace.WithAttributes(attribute.String("http.request.method", http.MethodGet), attribute.String("url.path", "/users"))
+
+	_, client := p.Tracer().Tracer("test").Start(context.Background(), "GET", trace.WithSpanKind(trace.SpanKindClient), attrs)
+	client.End()
+	require.True(t, client.SpanContext().IsSampled())
+
+	_, server := p.Tracer().Tracer("test").Start(context.Background(), "GET", trace.WithSpanKind(trace.SpanKindServer), attrs)
+	server.End()
+	require.False(t, server.SpanContext().IsSampled())
+}
+
+// TestSampler_RulesParent verifies the sampling rules apply to the root spans only,
+// the requests continuing an upstream trace keep the parent-based decision.
+func TestSampler_RulesParent(t *testing.T) {
+	p := &otel.Plugin{}
+	require.NoError(t, p.Init(newConfigurer(&otel.Config{
+		Exporter: otel.Exporter("stdout"),
+		Sampler: &otel.Sampler{
+			Type:  "parentbased_always_on",
+			Rules: []*otel.SamplingRule{{Ratio: 0}},
+		},
+	}), mockLogger{}))
+	t.Cleanup(func() { _ = p.Stop(context.Background()) })
+
+	var sampled bool
+	srv := p.Middleware(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		sampled = trace.SpanContextFromContext(r.Context()).IsSampled()
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/users", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	srv.ServeHTTP(httptest.NewRecorder(), req)
+	require.True(t, sampled, "the sampled upstream trace must be continued")
+
+	srv.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/users", nil))
+	require.False(t, sampled, "the root span must be sampled by the rule")
+}
+
+// TestSampler_InvalidRuleRegex verifies an invalid rule regex fails Plugin.Init.
+func TestSampler_InvalidRuleRegex(t *testing.T) {
+	p := &otel.Plugin{}
+	err := p.Init(newConfigurer(&otel.Config{
+		Exporter: otel.Exporter("stdout"),
+		Sampler:  &otel.Sampler{Rules: []*otel.SamplingRule{{PathRegex: "(", Ratio: 1}}},
+	}), mockLogger{})
+	require.Error(t, err)
+}
+
+// TestSampler_TailSamplingPressure verifies the tail sampler never buffers more
+// than max_traces traces and reports the traces it had to drop.
+func TestSampler_TailSamplingPressure(t *testing.T) {
+	p := &otel.Plugin{}
+	require.NoError(t, p.Init(newConfigurer(&otel.Config{
+		Exporter:     otel.Exporter("stdout"),
+		TailSampling: &otel.TailSampling{DecisionWait: time.Minute, MaxTraces: 1},
+	}), mockLogger{}))
+
+	tr := p.Tracer().Tracer("test")
+	for range 3 {
+		ctx, root := tr.Start(context.Background(), "root")
+		for range 4 {
+			_, child := tr.Start(ctx, "child")
+			child.End()
+		}
+		root.End()
+	}
+
+	require.Equal(t, uint64(2), p.TailSamplingDropped(), "traces must be counted, not spans")
+	require.NoError(t, p.Stop(context.Background()))
+}
+
+// TestSampler_TailSamplingLateSpans verifies the spans ending after their trace was
+// decided follow the earlier decision, and the spans above max_spans_per_trace are dropped.
+func TestSampler_TailSamplingLateSpans(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "traces.jsonl")
+
+	p := &otel.Plugin{}
+	require.NoError(t, p.Init(newConfigurer(&otel.Config{
+		Exporter:     otel.Exporter("file"),
+		File:         &otel.File{Path: path},
+		Sampler:      &otel.Sampler{Type: "always_on"},
+		TailSampling: &otel.TailSampling{DecisionWait: time.Minute, Errors: true, MaxSpansPerTrace: 3},
+	}), mockLogger{}))
+
+	tr := p.Tracer().Tracer("test")
+	ctx, root := tr.Start(context.Background(), "root")
+	_, failed := tr.Start(ctx, "failed")
+	failed.SetStatus(codes.Error, "boom")
+	failed.End()
+	// decides the trace, it's kept because of the error
+	require.NoError(t, p.Tracer().ForceFlush(context.Background()))
+	root.End()
+
+	ctx, other := tr.Start(context.Background(), "other")
+	for range 3 {
+		_, child := tr.Start(ctx, "child")
+		child.SetStatus(codes.Error, "boom")
+		child.End()
+	}
+	other.End()
+
+	require.NoError(t, p.Stop(context.Background()))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	var names []string
+	for line := range strings.Lines(string(data)) {
+		var req struct {
+			ResourceSpans []struct {
+				ScopeSpans []struct {
+					Spans []struct {
+						Name string `json:"name"`
+					} `json:"spans"`
+				} `json:"scopeSpans"`
+			} `json:"resourceSpans"`
+		}
+		require.NoError(t, json.Unmarshal([]byte(line), &req))
+		for _, rs := range req.ResourceSpans {
+			for _, ss := range rs.ScopeSpans {
+				for _, span := range ss.Spans {
+					names = append(names, span.Name)
+				}
+			}
+		}
+	}
+	require.ElementsMatch(t, []string{"failed", "root", "child", "child", "child"}, names)
+}
+
+// TestSampler_Adaptive verifies the adaptive sampler starts with the full
+// probability and lowers it once the observed load exceeds the target rate,
+// recording the effective probability on the sampled spans.
+func TestSampler_Adaptive(t *testing.T) {
+	p := &otel.Plugin{}
+	require.NoError(t, p.Init(newConfigurer(&otel.Config{
+		Exporter: otel.Exporter("stdout"),
+		Sampler:  &otel.Sampler{Type: "adaptive", TargetRate: 10},
+	}), mockLogger{}))
+	t.Cleanup(func() { _ = p.Stop(context.Background()) })
+
+	tr := p.Tracer().Tracer("test")
+	probability := func(span trace.Span) float64 {
+		ro, ok := span.(sdktrace.ReadOnlySpan)
+		require.True(t, ok)
+		for _, attr := range ro.Attributes() {
+			if attr.Key == "sampling.probability" {
+				return attr.Value.AsFloat64()
+			}
+		}
+		t.Fatal("sampled span has no sampling.probability attribute")
+		return 0
+	}
+
+	_, first := tr.Start(context.Background(), "first")
+	require.True(t, first.SpanContext().IsSampled(), "the first window must sample everything")
+	require.InDelta(t, 1.0, probability(first), 1e-9)
+
+	// generate a load way above the target rate, then wait for the window to end
+	for range 5000 {
+		_, _ = tr.Start(context.Background(), "load")
+	}
+	time.Sleep(1100 * time.Millisecond)
+
+	for range 10000 {
+		_, span := tr.Start(context.Background(), "after")
+		if span.SpanContext().IsSampled() {
+			require.Less(t, probability(span), 0.1, "the probability must adapt to the load")
+			return
+		}
+	}
+	t.Fatal("no span was sampled after the probability adjustment")
+}
+
+// TestSampler_AdaptiveChildren verifies the adaptive sampler counts the traces,
+// not the spans: the child spans follow their parent and do not raise the rate.
+func TestSampler_AdaptiveChildren(t *testing.T) {
+	p := &otel.Plugin{}
+	require.NoError(t, p.Init(newConfigurer(&otel.Config{
+		Exporter: otel.Exporter("stdout"),
+		Sampler:  &otel.Sampler{Type: "adaptive", TargetRate: 10},
+	}), mockLogger{}))
+	t.Cleanup(func() { _ = p.Stop(context.Background()) })
+
+	tr := p.Tracer().Tracer("test")
+	ctx, root := tr.Start(context.Background(), "root")
+	require.True(t, root.SpanContext().IsSampled())
+	for range 5000 {
+		_, child := tr.Start(ctx, "child")
+		require.True(t, child.SpanContext().IsSampled(), "the children must follow the parent")
+	}
+	time.Sleep(1100 * time.Millisecond)
+
+	for range 20 {
+		_, span := tr.Start(context.Background(), "after")
+		require.True(t, span.SpanContext().IsSampled(), "a single trace must not lower the probability")
+	}
+}
+
+// TestSampler_Remote serves a per-operation strategy from a local stand-in of
+// the Jaeger sampling endpoint and verifies the spans are sampled by their name.
+func TestSampler_Remote(t *testing.T) {
+	strategies := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("service") != "remote-test" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = io.WriteString(w, `{
+			"strategyType": "PROBABILISTIC",
+			"probabilisticSampling": {"samplingRate": 0},
+			"operationSampling": {
+				"defaultSamplingProbability": 0,
+				"defaultLowerBoundTracesPerSecond": 0,
+				"perOperationStrategies": [
+					{"operation": "GET /checkout", "probabilisticSampling": {"samplingRate": 1}}
+				]
+			}
+		}`)
+	}))
+	t.Cleanup(strategies.Close)
+
+	p := &otel.Plugin{}
+	require.NoError(t, p.Init(newConfigurer(&otel.Config{
+		Exporter: otel.Exporter("stdout"),
+		Resource: &otel.Resource{ServiceNameKey: "remote-test"},
+		// the default keeps everything, so the drop below proves the strategy was applied
+		Sampler: &otel.Sampler{Type: "remote", Ratio: ptr(1.0), Remote: &otel.RemoteSampler{URL: strategies.URL}},
+	}), mockLogger{}))
+	t.Cleanup(func() { _ = p.Stop(context.Background()) })
+
+	tr := p.Tracer().Tracer("test")
+	require.Eventually(t, func() bool {
+		_, span := tr.Start(context.Background(), "GET /users")
+		return !span.SpanContext().IsSampled()
+	}, 5*time.Second, 10*time.Millisecond, "the remote default strategy must be applied")
+
+	_, span := tr.Start(context.Background(), "GET /checkout")
+	require.True(t, span.SpanContext().IsSampled(), "the per-operation strategy must be applied")
+}
+
+// TestSampler_RemoteInitFailure verifies the remote sampler stops polling when a
+// later step of Plugin.Init fails, since Stop is never called in that case.
+func TestSampler_RemoteInitFailure(t *testing.T) {
+	var polls atomic.Int64
+	strategies := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		polls.Add(1)
+		_, _ = io.WriteString(w, `{"strategyType": "PROBABILISTIC", "probabilisticSampling": {"samplingRate": 1}}`)
+	}))
+	t.Cleanup(strategies.Close)
+
+	p := &otel.Plugin{}
+	err := p.Init(newConfigurer(&otel.Config{
+		Exporter:    otel.Exporter("stdout"),
+		Propagators: []string{"unknown"},
+		Sampler:     &otel.Sampler{Type: "remote", Remote: &otel.RemoteSampler{URL: strategies.URL, PollingInterval: 10 * time.Millisecond}},
+	}), mockLogger{})
+	require.Error(t, err)
+
+	before := polls.Load()
+	time.Sleep(100 * time.Millisecond)
+	require.Equal(t, before, polls.Load(), "the remote sampler must not poll after a failed Init")
+}
+
+// TestSampler_RemoteUnavailable verifies the configured default is used when
+// the sampling endpoint can't be reached.
+func TestSampler_RemoteUnavailable(t *testing.T) {
+	unavailable := httptest.NewServer(http.NotFoundHandler())
+	unavailable.Close()
+
+	for _, ratio := range []float64{0, 1} {
+		p := &otel.Plugin{}
+		require.NoError(t, p.Init(newConfigurer(&otel.Config{
+			Exporter: otel.Exporter("stdout"),
+			Sampler:  &otel.Sampler{Type: "parentbased_remote", Ratio: ptr(ratio), Remote: &otel.RemoteSampler{URL: unavailable.URL}},
+		}), mockLogger{}))
+
+		_, span := p.Tracer().Tracer("test").Start(context.Background(), "span")
+		require.Equal(t, ratio == 1, span.SpanContext().IsSampled())
+		require.NoError(t, p.Stop(context.Background()))
+	}
+}